@@ -0,0 +1,230 @@
+// Package api implements a http api server to manage glider at runtime,
+// it also exports the metrics in prometheus text format on "/metrics".
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nadoo/glider/pkg/log"
+	"github.com/nadoo/glider/pkg/metrics"
+	"github.com/nadoo/glider/rule"
+)
+
+// Proxy is the rule proxy managed by the api server.
+type Proxy interface {
+	Groups() []*rule.FwdrGroup
+}
+
+// Server is the api server.
+type Server struct {
+	addr  string
+	token string
+	proxy Proxy
+	mux   *http.ServeMux
+}
+
+// Group is the status of a forwarder group.
+type Group struct {
+	Name       string       `json:"name"`
+	Strategy   string       `json:"strategy"`
+	Forwarders []*Forwarder `json:"forwarders"`
+}
+
+// Forwarder is the status of a forwarder.
+type Forwarder struct {
+	Addr     string `json:"addr"`
+	URL      string `json:"url"`
+	Priority uint32 `json:"priority"`
+	Enabled  bool   `json:"enabled"`
+	Failures uint32 `json:"failures"`
+	Latency  int64  `json:"latency"` // milliseconds
+}
+
+// NewServer returns a new api server, the requests which change forwarders
+// must carry token, or come from loopback addresses if token is empty.
+func NewServer(addr, token string, p Proxy) *Server {
+	s := &Server{addr: addr, token: token, proxy: p, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("GET /groups", s.listGroups)
+	s.mux.HandleFunc("GET /groups/{group}", s.getGroup)
+	s.mux.HandleFunc("POST /groups/{group}/forwarders/enable", s.authorized(s.enableForwarder))
+	s.mux.HandleFunc("POST /groups/{group}/forwarders/disable", s.authorized(s.disableForwarder))
+	s.mux.HandleFunc("POST /groups/{group}/forwarders/priority", s.authorized(s.setPriority))
+	s.mux.HandleFunc("POST /groups/{group}/forwarders/check", s.authorized(s.checkForwarder))
+	s.mux.Handle("GET /metrics", metrics.Handler())
+
+	return s
+}
+
+// ListenAndServe listens on server's addr and serves api requests.
+func (s *Server) ListenAndServe() {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		log.Fatalf("[api] failed to listen on %s: %v", s.addr, err)
+		return
+	}
+
+	log.F("[api] listening TCP on %s", s.addr)
+
+	srv := &http.Server{Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+	if err := srv.Serve(l); err != nil {
+		log.F("[api] server stopped: %v", err)
+	}
+}
+
+// authorized wraps h so it only serves the requests allowed to change forwarders.
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				log.F("[api] unauthorized %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+				return
+			}
+		} else if ap, err := netip.ParseAddrPort(r.RemoteAddr); err != nil || !ap.Addr().Unmap().IsLoopback() {
+			log.F("[api] forbidden %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			writeError(w, http.StatusForbidden, errors.New("only allowed from loopback addresses without api token"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups := s.proxy.Groups()
+	ret := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		ret = append(ret, groupStatus(g))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (s *Server) getGroup(w http.ResponseWriter, r *http.Request) {
+	g := s.group(r.PathValue("group"))
+	if g == nil {
+		writeError(w, http.StatusNotFound, errors.New("group not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, groupStatus(g))
+}
+
+func (s *Server) enableForwarder(w http.ResponseWriter, r *http.Request) {
+	s.withForwarder(w, r, func(g *rule.FwdrGroup, f *rule.Forwarder) error {
+		f.Enable()
+		return nil
+	})
+}
+
+func (s *Server) disableForwarder(w http.ResponseWriter, r *http.Request) {
+	s.withForwarder(w, r, func(g *rule.FwdrGroup, f *rule.Forwarder) error {
+		f.Disable()
+		return nil
+	})
+}
+
+func (s *Server) setPriority(w http.ResponseWriter, r *http.Request) {
+	s.withForwarder(w, r, func(g *rule.FwdrGroup, f *rule.Forwarder) error {
+		priority, err := strconv.ParseUint(r.FormValue("priority"), 10, 32)
+		if err != nil {
+			return errors.New("invalid priority: " + r.FormValue("priority"))
+		}
+		f.SetPriority(uint32(priority))
+		return nil
+	})
+}
+
+func (s *Server) checkForwarder(w http.ResponseWriter, r *http.Request) {
+	s.withForwarder(w, r, func(g *rule.FwdrGroup, f *rule.Forwarder) error {
+		return g.CheckForwarder(f)
+	})
+}
+
+// withForwarder finds the forwarder specified in request, calls fn on it and writes the forwarder status.
+func (s *Server) withForwarder(w http.ResponseWriter, r *http.Request, fn func(*rule.FwdrGroup, *rule.Forwarder) error) {
+	g := s.group(r.PathValue("group"))
+	if g == nil {
+		writeError(w, http.StatusNotFound, errors.New("group not found"))
+		return
+	}
+
+	f := g.Forwarder(r.FormValue("addr"))
+	if f == nil {
+		writeError(w, http.StatusNotFound, errors.New("forwarder not found"))
+		return
+	}
+
+	if err := fn(g, f); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	log.F("[api] %s %s from %s, forwarder: %s", r.Method, r.URL.Path, r.RemoteAddr, f.Addr())
+	writeJSON(w, http.StatusOK, forwarderStatus(f))
+}
+
+func (s *Server) group(name string) *rule.FwdrGroup {
+	for _, g := range s.proxy.Groups() {
+		if g.Name() == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func groupStatus(g *rule.FwdrGroup) *Group {
+	fwdrs := g.Forwarders()
+	ret := &Group{Name: g.Name(), Strategy: g.Strategy(), Forwarders: make([]*Forwarder, 0, len(fwdrs))}
+	for _, f := range fwdrs {
+		ret.Forwarders = append(ret.Forwarders, forwarderStatus(f))
+	}
+	return ret
+}
+
+func forwarderStatus(f *rule.Forwarder) *Forwarder {
+	return &Forwarder{
+		Addr:     f.Addr(),
+		URL:      redactURL(f.URL()),
+		Priority: f.Priority(),
+		Enabled:  f.Enabled(),
+		Failures: f.Failures(),
+		Latency:  time.Duration(f.Latency()).Milliseconds(),
+	}
+}
+
+// redactURL removes the user info of the forwarder url, which may be chained by ",".
+func redactURL(s string) string {
+	parts := strings.Split(s, ",")
+	for i, part := range parts {
+		scheme, rest, ok := strings.Cut(part, "://")
+		if !ok {
+			continue
+		}
+		authority := rest
+		if end := strings.IndexAny(rest, "/?#"); end >= 0 {
+			authority = rest[:end]
+		}
+		if at := strings.LastIndex(authority, "@"); at >= 0 {
+			parts[i] = scheme + "://" + rest[at+1:]
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
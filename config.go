@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
+	stdflag "flag"
 	"fmt"
+	"net/netip"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/nadoo/conflag"
 
@@ -13,46 +17,103 @@ import (
 	"github.com/nadoo/glider/rule"
 )
 
-var flag = conflag.New()
+var flag *conflag.Conflag
 
 // Config is global config struct.
 type Config struct {
 	Verbose    bool
+	LogLevel   string
+	LogFormat  string
+	AccessLog  string
 	LogFlags   int
 	TCPBufSize int
 	UDPBufSize int
 
-	Listens []string
+	Listens         []string
+	ShutdownTimeout int
 
-	Forwards []string
-	Strategy rule.Strategy
+	Forwards    []string
+	ForwardSubs []string
+	Strategy    rule.Strategy
 
 	RuleFiles []string
 	RulesDir  string
 
+	GeoIPFile       string
+	GeoSiteFile     string
+	RuleSetCacheDir string
+
+	BlockPage string
+	blockPage []byte
+
 	DNS       string
+	DNSDoH    string
+	DNSDoT    string
 	DNSConfig dns.Config
 
 	rules []*rule.Config
 
 	Services []string
+
+	API      string
+	APIToken string
 }
 
 func parseConfig() *Config {
+	conf, err := newConfig(stdflag.ExitOnError)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		os.Exit(-1)
+	}
+
+	// setup logger
+	level, _ := log.ParseLevel(conf.LogLevel)
+	if conf.Verbose {
+		level = log.LevelDebug
+	}
+	log.Set(level, conf.LogFormat, conf.LogFlags)
+
+	// tcpbufsize
+	if conf.TCPBufSize > 0 {
+		proxy.TCPBufSize = conf.TCPBufSize
+	}
+
+	// udpbufsize
+	if conf.UDPBufSize > 0 {
+		proxy.UDPBufSize = conf.UDPBufSize
+	}
+
+	return conf
+}
+
+// reloadConfig parses the command line and config files again,
+// errors are returned instead of exiting the program.
+func reloadConfig() (*Config, error) {
+	return newConfig(stdflag.ContinueOnError)
+}
+
+func newConfig(errorHandling stdflag.ErrorHandling) (*Config, error) {
 	conf := &Config{}
 
+	flag = conflag.New()
+	flag.Init(os.Args[0], errorHandling)
 	flag.SetOutput(os.Stdout)
 
 	scheme := flag.String("scheme", "", "show help message of proxy scheme, use 'all' to see all schemes")
 	example := flag.Bool("example", false, "show usage examples")
 
-	flag.BoolVar(&conf.Verbose, "verbose", false, "verbose mode")
+	flag.BoolVar(&conf.Verbose, "verbose", false, "verbose mode, same as -loglevel=debug")
+	flag.StringVar(&conf.LogLevel, "loglevel", "info", "log level: debug, info, warn or error")
+	flag.StringVar(&conf.LogFormat, "logformat", "text", "log format: text or json")
+	flag.StringVar(&conf.AccessLog, "accesslog", "", "access log file path, one json record for each closed connection")
 	flag.IntVar(&conf.LogFlags, "logflags", 19, "do not change it if you do not know what it is, ref: https://pkg.go.dev/log#pkg-constants")
 	flag.IntVar(&conf.TCPBufSize, "tcpbufsize", 32768, "tcp buffer size in Bytes")
 	flag.IntVar(&conf.UDPBufSize, "udpbufsize", 2048, "udp buffer size in Bytes")
 	flag.StringSliceUniqVar(&conf.Listens, "listen", nil, "listen url, see the URL section below")
+	flag.IntVar(&conf.ShutdownTimeout, "shutdowntimeout", 10, "time to wait for active connections to finish when shutting down(seconds)")
 
 	flag.StringSliceVar(&conf.Forwards, "forward", nil, "forward url, see the URL section below")
+	flag.StringSliceUniqVar(&conf.ForwardSubs, "forwardsub", nil, "forwarder subscription url, format: http[s]://HOST/PATH[#interval=SECONDS], supports ss, vmess, trojan and vless share links")
 	flag.StringVar(&conf.Strategy.Strategy, "strategy", "rr", `rr: Round Robin mode
 ha: High Availability mode
 lha: Latency based High Availability mode
@@ -75,27 +136,48 @@ check=disable: disable health check`)
 
 	flag.StringSliceUniqVar(&conf.RuleFiles, "rulefile", nil, "rule file path")
 	flag.StringVar(&conf.RulesDir, "rules-dir", "", "rule file folder")
+	flag.StringVar(&conf.GeoIPFile, "geoipfile", "", "geoip database file in MaxMind DB format, used by geoip in rule files")
+	flag.StringVar(&conf.GeoSiteFile, "geositefile", "", "geosite.dat file in v2ray format, used by geosite in rule files")
+	flag.StringVar(&conf.RuleSetCacheDir, "rulesetcachedir", "", "folder to cache the rule lists of ruleset in rule files (default USER_CACHE_DIR/glider/ruleset)")
+	flag.StringVar(&conf.BlockPage, "blockpage", "", "html file as the 403 page to the http requests blocked by block:// forwarder (default built-in page)")
 
 	// dns configs
 	flag.StringVar(&conf.DNS, "dns", "", "local dns server listen address")
-	flag.StringSliceUniqVar(&conf.DNSConfig.Servers, "dnsserver", []string{"8.8.8.8:53"}, "remote dns server address")
+	flag.StringVar(&conf.DNSDoH, "dnsdoh", "", "local DNS-over-HTTPS server listen address, format: [HOST]:PORT[/PATH]?cert=PATH&key=PATH, default path: /dns-query")
+	flag.StringVar(&conf.DNSDoT, "dnsdot", "", "local DNS-over-TLS server listen address, format: [HOST]:PORT?cert=PATH&key=PATH")
+	flag.StringSliceUniqVar(&conf.DNSConfig.Servers, "dnsserver", []string{"8.8.8.8:53"}, "remote dns server address, HOST[:PORT] or https://HOST[:PORT]/PATH for DNS-over-HTTPS, tls://HOST[:PORT] for DNS-over-TLS")
 	flag.BoolVar(&conf.DNSConfig.AlwaysTCP, "dnsalwaystcp", false, "always use tcp to query upstream dns servers no matter there is a forwarder or not")
 	flag.IntVar(&conf.DNSConfig.Timeout, "dnstimeout", 3, "timeout value used in multiple dnsservers switch(seconds)")
+	flag.StringVar(&conf.DNSConfig.Strategy, "dnsstrategy", "failover", "dnsservers strategy: failover, rr, parallel or fastest")
 	flag.IntVar(&conf.DNSConfig.MaxTTL, "dnsmaxttl", 1800, "maximum TTL value for entries in the CACHE(seconds)")
 	flag.IntVar(&conf.DNSConfig.MinTTL, "dnsminttl", 0, "minimum TTL value for entries in the CACHE(seconds)")
 	flag.IntVar(&conf.DNSConfig.CacheSize, "dnscachesize", 4096, "max number of dns response in CACHE")
 	flag.BoolVar(&conf.DNSConfig.CacheLog, "dnscachelog", false, "show query log of dns cache")
+	flag.StringSliceUniqVar(&conf.DNSConfig.CacheTypes, "dnscachetype", nil, "query type to cache, format: TYPE[/MAXTTL], e.g. MX/3600, all types are cached if not set")
+	flag.IntVar(&conf.DNSConfig.NegativeTTL, "dnsnegttl", 300, "maximum TTL value for negative answers(NXDOMAIN and NODATA) in the CACHE(seconds), 0 to disable")
+	flag.StringVar(&conf.DNSConfig.CacheFile, "dnscachefile", "", "file to save the dns cache on shutdown and periodically, it's loaded on startup")
 	flag.BoolVar(&conf.DNSConfig.NoAAAA, "dnsnoaaaa", false, "disable AAAA query")
-	flag.StringSliceUniqVar(&conf.DNSConfig.Records, "dnsrecord", nil, "custom dns record, format: domain/ip")
+	flag.StringSliceUniqVar(&conf.DNSConfig.Records, "dnsrecord", nil, "custom dns record, format: domain/ip[,ip...] or domain/TYPE/VALUE, TYPE: A, AAAA, CNAME, TXT, SRV, PTR")
+	flag.StringSliceUniqVar(&conf.DNSConfig.Hosts, "dnshosts", nil, "hosts file path of custom dns records, each line: IP NAME [NAME...] or in dnsrecord format")
+	flag.StringVar(&conf.DNSConfig.BlockMode, "dnsblock", "nxdomain", "answer to the queries of domains blocked by block:// forwarder or dnsblocklist: nxdomain, nodata, zero(0.0.0.0 and ::) or a sinkhole ip")
+	flag.StringSliceUniqVar(&conf.DNSConfig.BlockLists, "dnsblocklist", nil, "domain blocklist file path or http(s) url, in hosts, domain list or adblock format")
+	flag.StringSliceUniqVar(&conf.DNSConfig.AllowLists, "dnsallowlist", nil, "domain allowlist file path or http(s) url, overrides dnsblocklist, same format as dnsblocklist")
+	flag.IntVar(&conf.DNSConfig.BlockListInterval, "dnsblocklistinterval", 86400, "reload interval of dnsblocklist and dnsallowlist(seconds), 0 to disable")
 
 	// service configs
 	flag.StringSliceUniqVar(&conf.Services, "service", nil, "run specified services, format: SERVICE_NAME[,SERVICE_CONFIG]")
 
+	// api configs
+	flag.StringVar(&conf.API, "api", "", "http api and metrics server listen address, e.g. 127.0.0.1:9090")
+	flag.StringVar(&conf.APIToken, "apitoken", "", "token required by the api requests which change forwarders, in header 'Authorization: Bearer TOKEN', these requests are only allowed from loopback addresses if not set")
+
 	flag.Usage = usage
+	if errorHandling == stdflag.ContinueOnError {
+		flag.Usage = func() {}
+	}
+
 	if err := flag.Parse(); err != nil {
-		// flag.Usage()
-		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
-		os.Exit(-1)
+		return nil, err
 	}
 
 	if *scheme != "" {
@@ -108,30 +190,72 @@ check=disable: disable health check`)
 		os.Exit(0)
 	}
 
-	// setup logger
-	log.Set(conf.Verbose, conf.LogFlags)
+	if _, err := log.ParseLevel(conf.LogLevel); err != nil {
+		return nil, err
+	}
 
-	if len(conf.Listens) == 0 && conf.DNS == "" && len(conf.Services) == 0 {
-		// flag.Usage()
-		fmt.Fprintf(os.Stderr, "ERROR: listen url must be specified.\n")
-		os.Exit(-1)
+	if conf.LogFormat != "text" && conf.LogFormat != "json" {
+		return nil, fmt.Errorf("invalid log format: %s", conf.LogFormat)
 	}
 
-	// tcpbufsize
-	if conf.TCPBufSize > 0 {
-		proxy.TCPBufSize = conf.TCPBufSize
+	switch conf.DNSConfig.BlockMode {
+	case "nxdomain", "nodata", "zero":
+	default:
+		if _, err := netip.ParseAddr(conf.DNSConfig.BlockMode); err != nil {
+			return nil, fmt.Errorf("invalid dns block mode: %s", conf.DNSConfig.BlockMode)
+		}
 	}
 
-	// udpbufsize
-	if conf.UDPBufSize > 0 {
-		proxy.UDPBufSize = conf.UDPBufSize
+	switch conf.DNSConfig.Strategy {
+	case "failover", "rr", "parallel", "fastest":
+	default:
+		return nil, fmt.Errorf("invalid dns strategy: %s", conf.DNSConfig.Strategy)
 	}
 
-	loadRules(conf)
-	return conf
+	if len(conf.Listens) == 0 && conf.DNS == "" && conf.DNSDoH == "" && conf.DNSDoT == "" && len(conf.Services) == 0 {
+		return nil, errors.New("listen url must be specified")
+	}
+
+	if err := loadRules(conf); err != nil {
+		return nil, err
+	}
+
+	// dns cache file
+	if conf.DNSConfig.CacheFile != "" && !path.IsAbs(conf.DNSConfig.CacheFile) {
+		conf.DNSConfig.CacheFile = path.Join(flag.ConfDir(), conf.DNSConfig.CacheFile)
+	}
+
+	// dns hosts files
+	for i, file := range conf.DNSConfig.Hosts {
+		if !path.IsAbs(file) {
+			conf.DNSConfig.Hosts[i] = path.Join(flag.ConfDir(), file)
+		}
+	}
+
+	// dns blocklists and allowlists
+	for _, lists := range [][]string{conf.DNSConfig.BlockLists, conf.DNSConfig.AllowLists} {
+		for i, list := range lists {
+			if !strings.HasPrefix(list, "http://") && !strings.HasPrefix(list, "https://") && !path.IsAbs(list) {
+				lists[i] = path.Join(flag.ConfDir(), list)
+			}
+		}
+	}
+
+	if conf.BlockPage != "" {
+		if !path.IsAbs(conf.BlockPage) {
+			conf.BlockPage = path.Join(flag.ConfDir(), conf.BlockPage)
+		}
+		b, err := os.ReadFile(conf.BlockPage)
+		if err != nil {
+			return nil, err
+		}
+		conf.blockPage = b
+	}
+
+	return conf, nil
 }
 
-func loadRules(conf *Config) {
+func loadRules(conf *Config) error {
 	// rulefiles
 	for _, ruleFile := range conf.RuleFiles {
 		if !path.IsAbs(ruleFile) {
@@ -140,7 +264,7 @@ func loadRules(conf *Config) {
 
 		rule, err := rule.NewConfFromFile(ruleFile)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
 		conf.rules = append(conf.rules, rule)
@@ -155,11 +279,35 @@ func loadRules(conf *Config) {
 		for _, ruleFile := range ruleFolderFiles {
 			rule, err := rule.NewConfFromFile(ruleFile)
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 			conf.rules = append(conf.rules, rule)
 		}
 	}
+
+	// geoip and geosite files
+	for _, file := range []*string{&conf.GeoIPFile, &conf.GeoSiteFile} {
+		if *file != "" && !path.IsAbs(*file) {
+			*file = path.Join(flag.ConfDir(), *file)
+		}
+	}
+
+	// ruleset cache folder
+	if conf.RuleSetCacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			dir = os.TempDir()
+		}
+		conf.RuleSetCacheDir = path.Join(dir, "glider", "ruleset")
+	} else if !path.IsAbs(conf.RuleSetCacheDir) {
+		conf.RuleSetCacheDir = path.Join(flag.ConfDir(), conf.RuleSetCacheDir)
+	}
+
+	if err := rule.LoadRuleSets(conf.rules, conf.RuleSetCacheDir); err != nil {
+		return err
+	}
+
+	return rule.LoadGeoData(conf.rules, conf.GeoIPFile, conf.GeoSiteFile)
 }
 
 func usage() {
@@ -205,8 +353,8 @@ Forwarder Options: FORWARD_URL#OPTIONS
         -forward socks5://server:1080#priority=100&interface=192.168.1.99
 
 Services:
-   dhcpd: service=dhcpd,INTERFACE,START_IP,END_IP,LEASE_MINUTES[,MAC=IP,MAC=IP...]
-          service=dhcpd-failover,INTERFACE,START_IP,END_IP,LEASE_MINUTES[,MAC=IP,MAC=IP...]
+   dhcpd: service=dhcpd,INTERFACE,START_IP,END_IP,LEASE_MINUTES[,MAC=IP,MAC=IP...][,domain=DOMAIN]
+          service=dhcpd-failover,INTERFACE,START_IP,END_IP,LEASE_MINUTES[,MAC=IP,MAC=IP...][,domain=DOMAIN]
      e.g. service=dhcpd,eth1,192.168.1.100,192.168.1.199,720
 
 --
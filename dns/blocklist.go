@@ -0,0 +1,281 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nadoo/glider/pkg/log"
+	"github.com/nadoo/glider/pkg/metrics"
+)
+
+const (
+	blocklistFetchTimeout = 30 * time.Second
+	blocklistMaxSize      = 64 << 20
+)
+
+var blocklistHits = metrics.NewCounterVec("glider_dns_blocklist_hits_total",
+	"Total number of dns queries matched the blocklists and allowlists.", "list", "action")
+
+// Blocklist is a set of blocked domains and the allowed ones which override them.
+type Blocklist struct {
+	block, allow domainSet
+}
+
+// domainSet is a set of domains, the values are the lists which the domains come from.
+type domainSet struct {
+	full   map[string]string // the domain only
+	suffix map[string]string // the domain and its subdomains
+}
+
+func (s *domainSet) add(domain, list string, suffix bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain == "" {
+		return
+	}
+
+	m := &s.full
+	if suffix {
+		m = &s.suffix
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	if _, ok := (*m)[domain]; !ok {
+		(*m)[domain] = list
+	}
+}
+
+func (s *domainSet) match(domain string) (string, bool) {
+	if list, ok := s.full[domain]; ok {
+		return list, true
+	}
+
+	for i := len(domain); i != -1; {
+		i = strings.LastIndexByte(domain[:i], '.')
+		if list, ok := s.suffix[domain[i+1:]]; ok {
+			return list, true
+		}
+	}
+	return "", false
+}
+
+func (s *domainSet) len() int { return len(s.full) + len(s.suffix) }
+
+// Match reports whether domain is blocked and not allowed, the list which blocks it is returned.
+func (b *Blocklist) Match(domain string) (string, bool) {
+	if b == nil {
+		return "", false
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	list, ok := b.block.match(domain)
+	if !ok {
+		return "", false
+	}
+
+	if allow, ok := b.allow.match(domain); ok {
+		blocklistHits.With(allow, "allow").Inc()
+		return "", false
+	}
+
+	blocklistHits.With(list, "block").Inc()
+	return list, true
+}
+
+// parse parses the content of list, format of each line:
+//
+//	hosts format: IP DOMAIN [DOMAIN...], the domains are blocked
+//	domain list format: DOMAIN, the domain is blocked
+//	adblock syntax: ||DOMAIN^, the domain and its subdomains are blocked
+//	adblock exception: @@||DOMAIN^, the domain and its subdomains are allowed
+//
+// all the domains in allowlist are allowed, unsupported adblock rules are ignored.
+func (b *Blocklist) parse(content []byte, list string, allowlist bool) {
+	block := &b.block
+	if allowlist {
+		block = &b.allow
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(content))
+	s.Buffer(nil, 64<<10)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "@@") {
+			if domain, ok := parseAdblockRule(line[2:]); ok {
+				b.allow.add(domain, list, true)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "||") {
+			if domain, ok := parseAdblockRule(line); ok {
+				block.add(domain, list, true)
+			}
+			continue
+		}
+
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			if len(fields) == 1 && isDomain(fields[0]) {
+				block.add(fields[0], list, false)
+			}
+			continue
+		}
+
+		for _, domain := range fields[1:] {
+			if isDomain(domain) && !localHosts[strings.ToLower(domain)] {
+				block.add(domain, list, false)
+			}
+		}
+	}
+}
+
+// localHosts are the names in hosts files which should not be blocked.
+var localHosts = map[string]bool{
+	"localhost": true, "localhost.localdomain": true, "local": true, "broadcasthost": true,
+	"ip6-localhost": true, "ip6-loopback": true, "ip6-localnet": true, "ip6-mcastprefix": true,
+	"ip6-allnodes": true, "ip6-allrouters": true, "ip6-allhosts": true, "0.0.0.0": true,
+}
+
+// parseAdblockRule returns the domain in adblock rule `||DOMAIN^`, rules with options or paths are not supported.
+func parseAdblockRule(rule string) (string, bool) {
+	if !strings.HasPrefix(rule, "||") {
+		return "", false
+	}
+
+	domain, ok := strings.CutSuffix(strings.TrimSuffix(rule[2:], "|"), "^")
+	if !ok || !isDomain(domain) {
+		return "", false
+	}
+	return domain, true
+}
+
+// isDomain reports whether s looks like a domain name.
+func isDomain(s string) bool {
+	if s == "" || len(s) > 253 || s[0] == '.' || s[0] == '-' {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// blocklists loads the blocklists and allowlists of the dns client.
+type blocklists struct {
+	mu    sync.Mutex
+	block []string
+	allow []string
+	data  map[string][]byte // the last loaded content of the lists
+}
+
+// SetBlocklists sets the blocklists and allowlists and loads them in background,
+// each list is a file path or a http(s) url.
+func (c *Client) SetBlocklists(block, allow []string) {
+	c.lists.mu.Lock()
+	c.lists.block, c.lists.allow = block, allow
+	c.lists.mu.Unlock()
+
+	go c.LoadBlocklists()
+}
+
+// LoadBlocklists loads the blocklists and allowlists again, the last loaded
+// content is used for the lists failed to load.
+func (c *Client) LoadBlocklists() {
+	c.lists.mu.Lock()
+	defer c.lists.mu.Unlock()
+
+	if len(c.lists.block) == 0 {
+		c.blocklist.Store(nil)
+		c.lists.data = nil
+		return
+	}
+
+	httpClient := &http.Client{
+		Timeout: blocklistFetchTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				rc, _, err := c.proxy.Dial(nil, network, addr)
+				return rc, err
+			},
+		},
+	}
+	defer httpClient.CloseIdleConnections()
+
+	b := &Blocklist{}
+	data := make(map[string][]byte)
+	load := func(list string, allowlist bool) {
+		content, err := readList(httpClient, list)
+		if err != nil {
+			log.Warn("[dns] load blocklist error", "list", list, "error", err.Error())
+			if content = c.lists.data[list]; content == nil {
+				return
+			}
+		}
+		data[list] = content
+		b.parse(content, list, allowlist)
+	}
+
+	for _, list := range c.lists.block {
+		load(list, false)
+	}
+	for _, list := range c.lists.allow {
+		load(list, true)
+	}
+
+	c.lists.data = data
+	c.blocklist.Store(b)
+
+	log.Info("[dns] blocklists loaded", "lists", len(c.lists.block), "allowlists", len(c.lists.allow),
+		"blocked", b.block.len(), "allowed", b.allow.len())
+}
+
+// readList reads the content of list from the file or http(s) url.
+func readList(client *http.Client, list string) ([]byte, error) {
+	if !strings.HasPrefix(list, "http://") && !strings.HasPrefix(list, "https://") {
+		return os.ReadFile(list)
+	}
+
+	resp, err := client.Get(list)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, blocklistMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > blocklistMaxSize {
+		return nil, errors.New("list too large")
+	}
+	return b, nil
+}
@@ -1,10 +1,17 @@
 package dns
 
 import (
+	"encoding/json"
+	"io"
 	"sync"
 	"time"
+
+	"github.com/nadoo/glider/pkg/metrics"
 )
 
+var cacheLookups = metrics.NewCounterVec("glider_dns_cache_lookups_total",
+	"Total number of dns cache lookups by result: hit, expired or miss.", "result")
+
 // LruCache is the struct of LruCache.
 type LruCache struct {
 	mu    sync.Mutex
@@ -46,6 +53,7 @@ func (c *LruCache) Get(k string) (v []byte, expired bool) {
 	defer c.mu.Unlock()
 
 	if v, ok := c.store[k]; ok {
+		cacheLookups.With("hit").Inc()
 		return v, false
 	}
 
@@ -56,6 +64,15 @@ func (c *LruCache) Get(k string) (v []byte, expired bool) {
 		}
 		c.moveToHead(it)
 	}
+
+	switch {
+	case v == nil:
+		cacheLookups.With("miss").Inc()
+	case expired:
+		cacheLookups.With("expired").Inc()
+	default:
+		cacheLookups.With("hit").Inc()
+	}
 	return
 }
 
@@ -90,6 +107,75 @@ func (c *LruCache) Set(k string, v []byte, ttl int) {
 	}
 }
 
+// Range calls f sequentially for each key and value present in the cache,
+// not including the never expired items. f is called without holding the lock.
+func (c *LruCache) Range(f func(k string, v []byte)) {
+	c.mu.Lock()
+	items := make([]*item, 0, len(c.cache))
+	for it := c.head; it != nil; it = it.next {
+		if it.val != nil {
+			items = append(items, &item{key: it.key, val: it.val})
+		}
+	}
+	c.mu.Unlock()
+
+	for _, it := range items {
+		f(it.key, it.val)
+	}
+}
+
+// cacheEntry is the item saved in cache file.
+type cacheEntry struct {
+	Key string `json:"key"`
+	Val []byte `json:"val"`
+	Exp int64  `json:"exp"`
+}
+
+// SaveTo writes the items with their expiry to w in json format,
+// not including the never expired items.
+func (c *LruCache) SaveTo(w io.Writer) error {
+	c.mu.Lock()
+	entries := make([]cacheEntry, 0, len(c.cache))
+	for it := c.head; it != nil; it = it.next {
+		if it.val != nil {
+			entries = append(entries, cacheEntry{Key: it.key, Val: it.val, Exp: it.exp})
+		}
+	}
+	c.mu.Unlock()
+
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// LoadFrom loads the items saved by SaveTo, the expired items are kept so they
+// can be served and refreshed, the existing items are not replaced.
+func (c *LruCache) LoadFrom(r io.Reader) (int, error) {
+	var entries []cacheEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the items are saved from head to tail, so put them to head reversely.
+	n := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if _, ok := c.cache[e.Key]; ok || e.Val == nil {
+			continue
+		}
+
+		c.putToHead(e.Key, e.Val, e.Exp)
+		n++
+
+		if len(c.cache) > c.size {
+			c.removeTail()
+		}
+	}
+
+	return n, nil
+}
+
 // putToHead puts a new item to cache's head.
 func (c *LruCache) putToHead(k string, v []byte, exp int64) {
 	it := &item{key: k, val: v, exp: exp, prev: nil, next: c.head}
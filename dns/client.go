@@ -1,13 +1,18 @@
 package dns
 
 import (
+	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nadoo/glider/pkg/log"
@@ -18,6 +23,9 @@ import (
 // AnswerHandler function handles the dns TypeA or TypeAAAA answer.
 type AnswerHandler func(domain string, ip netip.Addr) error
 
+// blockTTL is the ttl of the answers to blocked queries.
+const blockTTL = 60
+
 // Config for dns.
 type Config struct {
 	Servers   []string
@@ -25,10 +33,21 @@ type Config struct {
 	MaxTTL    int
 	MinTTL    int
 	Records   []string
+	Hosts     []string
 	AlwaysTCP bool
 	CacheSize int
 	CacheLog  bool
 	NoAAAA    bool
+	BlockMode string
+	Strategy  string
+
+	CacheFile   string
+	CacheTypes  []string
+	NegativeTTL int
+
+	BlockLists        []string
+	AllowLists        []string
+	BlockListInterval int
 }
 
 // Client is a dns client struct.
@@ -37,8 +56,18 @@ type Client struct {
 	cache       *LruCache
 	config      *Config
 	upStream    *UPStream
+	upStreamMu  sync.RWMutex
 	upStreamMap map[string]*UPStream
 	handlers    []AnswerHandler
+	records     atomic.Pointer[Records]
+	hosts       Records        // hosts added by AddHost
+	cacheTypes  map[uint16]int // cached query types and their max ttl, nil means all types
+	blocklist   atomic.Pointer[Blocklist]
+	lists       blocklists
+	dohs        sync.Map // DNS-over-HTTPS upstreams by forwarder and server, map[string]*dohUpstream
+	dotMu       sync.Mutex
+	dots        map[string]*dotConn // DNS-over-TLS connections by forwarder and server
+	dotDials    map[string]*dotDial // DNS-over-TLS connections being dialed
 }
 
 // NewClient returns a new dns client.
@@ -49,15 +78,33 @@ func NewClient(proxy proxy.Proxy, config *Config) (*Client, error) {
 		config:      config,
 		upStream:    NewUPStream(config.Servers),
 		upStreamMap: make(map[string]*UPStream),
+		lists:       blocklists{block: config.BlockLists, allow: config.AllowLists},
 	}
 
-	// custom records
-	for _, record := range config.Records {
-		if err := c.AddRecord(record); err != nil {
-			log.F("[dns] add record '%s' error: %s", record, err)
+	// cached query types
+	for _, t := range config.CacheTypes {
+		name, ttl, found := strings.Cut(t, "/")
+		qtype, err := ParseQType(name)
+		if err != nil {
+			return nil, err
+		}
+
+		maxTTL := 0
+		if found {
+			if maxTTL, err = strconv.Atoi(ttl); err != nil || maxTTL <= 0 {
+				return nil, fmt.Errorf("invalid max ttl of cache type: %s", t)
+			}
 		}
+
+		if c.cacheTypes == nil {
+			c.cacheTypes = make(map[uint16]int)
+		}
+		c.cacheTypes[qtype] = maxTTL
 	}
 
+	// custom records
+	c.SetRecords(config.Records, config.Hosts)
+
 	return c, nil
 }
 
@@ -75,21 +122,39 @@ func (c *Client) Exchange(reqBytes []byte, clientAddr string, preferTCP bool) ([
 		return respBytes, nil
 	}
 
-	if req.Question.QTYPE == QTypeA || req.Question.QTYPE == QTypeAAAA {
+	if respBytes, ok := c.localAnswer(req, preferTCP); ok {
+		log.Debug("[dns] query", "client", clientAddr, "qname", req.Question.QNAME,
+			"qtype", req.Question.QTYPE, "server", "local")
+		return respBytes, nil
+	}
+
+	if list, ok := c.blocklist.Load().Match(req.Question.QNAME); ok {
+		log.Debug("[dns] blocked by blocklist", "client", clientAddr, "qname", req.Question.QNAME,
+			"qtype", req.Question.QTYPE, "list", list)
+		return c.blockResponse(req)
+	}
+
+	if group, ok := c.blocked(req.Question.QNAME); ok {
+		proxy.LogBlocked(group, "dns", clientAddr, req.Question.QNAME)
+		return c.blockResponse(req)
+	}
+
+	cacheable := c.cacheable(req.Question.QTYPE)
+	if cacheable {
 		if v, expired := c.cache.Get(qKey(req.Question)); len(v) > 2 {
 			v = valCopy(v)
 			binary.BigEndian.PutUint16(v[:2], req.ID)
 
 			if c.config.CacheLog {
-				log.F("[dns] %s <-> cache, type: %d, %s",
-					clientAddr, req.Question.QTYPE, req.Question.QNAME)
+				log.Debug("[dns] query", "client", clientAddr, "qname", req.Question.QNAME,
+					"qtype", req.Question.QTYPE, "server", "cache")
 			}
 
 			if expired { // update cache
 				go func(qname string, reqBytes []byte, preferTCP bool) {
 					defer pool.PutBuffer(reqBytes)
 					if dnsServer, network, dialerAddr, respBytes, err := c.exchange(qname, reqBytes, preferTCP); err == nil {
-						c.handleAnswer(respBytes, "cache", dnsServer, network, dialerAddr)
+						c.handleAnswer(respBytes, true, "cache", dnsServer, network, dialerAddr)
 					}
 				}(req.Question.QNAME, valCopy(reqBytes), preferTCP)
 			}
@@ -102,58 +167,148 @@ func (c *Client) Exchange(reqBytes []byte, clientAddr string, preferTCP bool) ([
 		return nil, err
 	}
 
-	if req.Question.QTYPE != QTypeA && req.Question.QTYPE != QTypeAAAA {
-		log.F("[dns] %s <-> %s(%s) via %s, type: %d, %s",
-			clientAddr, dnsServer, network, dialerAddr, req.Question.QTYPE, req.Question.QNAME)
-		return respBytes, nil
+	// the response is passed through even if it can not be parsed
+	if err := c.handleAnswer(respBytes, cacheable, clientAddr, dnsServer, network, dialerAddr); err != nil {
+		log.Debug("[dns] failed to parse answer", "client", clientAddr, "qname", req.Question.QNAME,
+			"qtype", req.Question.QTYPE, "server", dnsServer, "error", err.Error())
 	}
-
-	err = c.handleAnswer(respBytes, clientAddr, dnsServer, network, dialerAddr)
-	return respBytes, err
+	return respBytes, nil
 }
 
-func (c *Client) handleAnswer(respBytes []byte, clientAddr, dnsServer, network, dialerAddr string) error {
+// handleAnswer calls the answer handlers with the answers in resp, and caches resp if cacheable.
+func (c *Client) handleAnswer(respBytes []byte, cacheable bool, clientAddr, dnsServer, network, dialerAddr string) error {
 	resp, err := UnmarshalMessage(respBytes)
 	if err != nil {
 		return err
 	}
 
-	ips, ttl := c.extractAnswer(resp)
-	if ttl > c.config.MaxTTL {
-		ttl = c.config.MaxTTL
-	} else if ttl < c.config.MinTTL {
-		ttl = c.config.MinTTL
-	}
+	ips := c.extractAnswer(resp)
 
-	if ttl <= 0 { // we got a null result
-		ttl = 1800
+	var ttl int
+	if cacheable {
+		ttl = c.cacheTTL(resp)
+	}
+	if ttl > 0 {
+		c.cache.Set(qKey(resp.Question), valCopy(respBytes), ttl)
 	}
 
-	c.cache.Set(qKey(resp.Question), valCopy(respBytes), ttl)
-	log.F("[dns] %s <-> %s(%s) via %s, %s/%d: %s, ttl: %ds",
-		clientAddr, dnsServer, network, dialerAddr, resp.Question.QNAME, resp.Question.QTYPE, strings.Join(ips, ","), ttl)
+	log.Debug("[dns] query", "client", clientAddr, "qname", resp.Question.QNAME, "qtype", resp.Question.QTYPE,
+		"server", dnsServer, "network", network, "forwarder", dialerAddr, "answers", strings.Join(ips, ","), "ttl", ttl)
 
 	return nil
 }
 
-func (c *Client) extractAnswer(resp *Message) ([]string, int) {
+// extractAnswer calls the answer handlers with the A and AAAA answers, and returns the ips.
+func (c *Client) extractAnswer(resp *Message) []string {
 	var ips []string
-	ttl := c.config.MinTTL
 	for _, answer := range resp.Answers {
-		if answer.TYPE == QTypeA || answer.TYPE == QTypeAAAA {
-			if answer.IP.IsValid() && !answer.IP.IsUnspecified() {
-				for _, h := range c.handlers {
-					h(resp.Question.QNAME, answer.IP)
-				}
-				ips = append(ips, answer.IP.String())
+		if answer.IP.IsValid() && !answer.IP.IsUnspecified() {
+			for _, h := range c.handlers {
+				h(resp.Question.QNAME, answer.IP)
 			}
-			if answer.TTL != 0 {
+			ips = append(ips, answer.IP.String())
+		}
+	}
+	return ips
+}
+
+// cacheable reports whether the answers of qtype should be cached.
+func (c *Client) cacheable(qtype uint16) bool {
+	if c.cacheTypes == nil {
+		return true
+	}
+	_, ok := c.cacheTypes[qtype]
+	return ok
+}
+
+// cacheTTL returns the ttl to cache resp, 0 means resp should not be cached.
+// the ttl of negative answers(NXDOMAIN and NODATA) is the minimum of SOA ttl
+// and SOA MINIMUM field in the authority section, RFC 2308.
+func (c *Client) cacheTTL(resp *Message) int {
+	if resp.Bits&(1<<9) != 0 { // truncated
+		return 0
+	}
+
+	maxTTL := c.config.MaxTTL
+	if ttl := c.cacheTypes[resp.Question.QTYPE]; ttl > 0 {
+		maxTTL = ttl
+	}
+
+	switch rcode := resp.Rcode(); {
+	case rcode == RcodeSuccess && len(resp.Answers) > 0:
+		ttl := 0
+		for _, answer := range resp.Answers {
+			if answer.TTL != 0 && (ttl == 0 || int(answer.TTL) < ttl) {
 				ttl = int(answer.TTL)
 			}
 		}
+		return min(max(ttl, c.config.MinTTL), maxTTL)
+
+	case rcode == RcodeSuccess, rcode == RcodeNXDomain:
+		if soa, ok := resp.SOA(); ok {
+			if minimum, ok := soa.SOAMinimum(); ok {
+				return min(int(min(soa.TTL, minimum)), c.config.NegativeTTL, maxTTL)
+			}
+		}
+	}
+
+	// negative answers without SOA and other errors are not cached
+	return 0
+}
+
+// blocked reports whether qname is blocked by rules, and returns the rule group which blocks it.
+// the rule is matched without scheduling a dialer if the proxy supports it, so cached queries
+// don't affect the forwarder rotation.
+func (c *Client) blocked(qname string) (group string, ok bool) {
+	if p, ok := c.proxy.(interface {
+		Blocked(meta *proxy.Metadata, network, dstAddr string) (string, bool)
+	}); ok {
+		return p.Blocked(nil, "", qname+":0")
 	}
 
-	return ips, ttl
+	dialer := c.proxy.NextDialer(nil, "", qname+":0")
+	// TODO: dialer.Addr() == "BLOCK", tricky
+	if dialer.Addr() != "BLOCK" {
+		return "", false
+	}
+	if g, ok := dialer.(interface{ Group() string }); ok {
+		group = g.Group()
+	}
+	return group, true
+}
+
+// blockResponse makes the response of the blocked request by block mode:
+// NXDOMAIN, NODATA, or the sinkhole address for A and AAAA queries.
+func (c *Client) blockResponse(req *Message) ([]byte, error) {
+	m := NewMessage(req.ID, ResponseMsg)
+	m.Bits |= req.Bits&(1<<8) | 1<<7 // RD copied from request, RA
+	m.SetQuestion(req.Question)
+
+	var ip4, ip6 netip.Addr
+	switch c.config.BlockMode {
+	case "nxdomain":
+		m.SetRcode(3)
+	case "nodata":
+	case "zero":
+		ip4, ip6 = netip.IPv4Unspecified(), netip.IPv6Unspecified()
+	default:
+		if ip, err := netip.ParseAddr(c.config.BlockMode); err == nil && ip.Is4() {
+			ip4 = ip
+		} else if err == nil {
+			ip6 = ip
+		}
+	}
+
+	q := req.Question
+	if q.QTYPE == QTypeA && ip4.IsValid() {
+		m.AddAnswer(&RR{NAME: q.QNAME, TYPE: QTypeA, CLASS: ClassINET,
+			TTL: blockTTL, RDLENGTH: net.IPv4len, RDATA: ip4.AsSlice()})
+	} else if q.QTYPE == QTypeAAAA && ip6.IsValid() {
+		m.AddAnswer(&RR{NAME: q.QNAME, TYPE: QTypeAAAA, CLASS: ClassINET,
+			TTL: blockTTL, RDLENGTH: net.IPv6len, RDATA: ip6.AsSlice()})
+	}
+
+	return m.Marshal()
 }
 
 // exchange choose a upstream dns server based on qname, communicate with it on the network.
@@ -162,13 +317,13 @@ func (c *Client) exchange(qname string, reqBytes []byte, preferTCP bool) (
 
 	// use tcp to connect upstream server default
 	network = "tcp"
-	dialer := c.proxy.NextDialer(qname + ":0")
+	dialer := c.proxy.NextDialer(nil, "", qname+":0")
 
 	// if we are resolving a domain which uses a forwarder `REJECT`, then use `DIRECT` instead
 	// so we can resolve it correctly.
 	// TODO: dialer.Addr() == "REJECT", tricky
 	if dialer.Addr() == "REJECT" {
-		dialer = c.proxy.NextDialer("direct:0")
+		dialer = c.proxy.NextDialer(nil, "", "direct:0")
 	}
 
 	// If client uses udp and no forwarders specified, use udp
@@ -178,48 +333,161 @@ func (c *Client) exchange(qname string, reqBytes []byte, preferTCP bool) (
 	}
 
 	ups := c.UpStream(qname)
-	server = ups.Server()
-	for range ups.Len() {
-		var rc net.Conn
-		rc, err = dialer.Dial(network, server)
-		if err != nil {
+	switch c.config.Strategy {
+	case "parallel":
+		server, respBytes, err = c.exchangeParallel(ups, dialer, network, qname, reqBytes)
+
+	case "fastest":
+		servers := ups.Sorted()
+		for i, s := range servers {
+			server = s
+			if respBytes, err = c.exchangeServer(ups, dialer, network, server, qname, reqBytes); err == nil {
+				break
+			}
+
+			if i < len(servers)-1 {
+				log.Warn("[dns] failed to exchange with server", "qname", qname, "server", server,
+					"forwarder", dialer.Addr(), "error", err.Error(), "next_server", servers[i+1])
+			}
+		}
+
+	default: // failover and rr
+		server = ups.Server()
+		if c.config.Strategy == "rr" {
+			server = ups.Switch()
+		}
+
+		for range ups.Len() {
+			if respBytes, err = c.exchangeServer(ups, dialer, network, server, qname, reqBytes); err == nil {
+				break
+			}
+
 			newServer := ups.SwitchIf(server)
-			log.F("[dns] error in resolving %s, failed to connect to server %v via %s: %v, next server: %s",
-				qname, server, dialer.Addr(), err, newServer)
+			log.Warn("[dns] failed to exchange with server", "qname", qname, "server", server,
+				"forwarder", dialer.Addr(), "error", err.Error(), "next_server", newServer)
+
 			server = newServer
-			continue
 		}
-		defer rc.Close()
+	}
+
+	if isDoHServer(server) {
+		network = "https"
+	} else if isDoTServer(server) {
+		network = "tls"
+	}
+
+	// if all dns upstreams failed, then maybe the forwarder is not available.
+	if err != nil {
+		c.proxy.Record(dialer, false)
+	}
+
+	return server, network, dialer.Addr(), respBytes, err
+}
+
+// exchangeServer exchanges with server and records the response time to ups.
+func (c *Client) exchangeServer(ups *UPStream, dialer proxy.Dialer, network, server, qname string, reqBytes []byte) (
+	respBytes []byte, err error) {
+
+	start := time.Now()
+	if isDoHServer(server) {
+		respBytes, err = c.exchangeDoH(dialer, qname, server, reqBytes)
+	} else if isDoTServer(server) {
+		respBytes, err = c.exchangeDoT(dialer, server, reqBytes)
+	} else {
+		respBytes, err = c.exchangeConn(dialer, network, server, reqBytes)
+	}
+	ups.Record(server, time.Since(start), err == nil)
 
-		// TODO: support timeout setting for different upstream server
-		if c.config.Timeout > 0 {
-			rc.SetDeadline(time.Now().Add(time.Duration(c.config.Timeout) * time.Second))
+	return respBytes, err
+}
+
+// exchangeParallel exchanges with all the servers of ups concurrently, the first valid
+// response wins, or the first response if none of them is valid.
+func (c *Client) exchangeParallel(ups *UPStream, dialer proxy.Dialer, network, qname string, reqBytes []byte) (
+	server string, respBytes []byte, err error) {
+
+	type result struct {
+		server    string
+		respBytes []byte
+		err       error
+	}
+
+	// the request is still in use by the slow ones after returned
+	reqBytes = bytes.Clone(reqBytes)
+
+	servers := ups.Servers()
+	results := make(chan result, len(servers))
+	for _, server := range servers {
+		go func() {
+			respBytes, err := c.exchangeServer(ups, dialer, network, server, qname, reqBytes)
+			results <- result{server, respBytes, err}
+		}()
+	}
+
+	var first *result
+	for i := range servers {
+		r := <-results
+		if r.err != nil {
+			log.Warn("[dns] failed to exchange with server", "qname", qname, "server", r.server,
+				"forwarder", dialer.Addr(), "error", r.err.Error())
+			err = r.err
+			continue
 		}
 
-		switch network {
-		case "tcp":
-			respBytes, err = c.exchangeTCP(rc, reqBytes)
-		case "udp":
-			respBytes, err = c.exchangeUDP(rc, reqBytes)
+		if validResponse(r.respBytes) {
+			if first != nil {
+				pool.PutBuffer(first.respBytes)
+			}
+
+			// release the responses of the slow ones
+			go func(n int) {
+				for range n {
+					pool.PutBuffer((<-results).respBytes)
+				}
+			}(len(servers) - i - 1)
+
+			return r.server, r.respBytes, nil
 		}
 
-		if err == nil {
-			break
+		if first == nil {
+			first = &r
+		} else {
+			pool.PutBuffer(r.respBytes)
 		}
+	}
 
-		newServer := ups.SwitchIf(server)
-		log.F("[dns] error in resolving %s, failed to exchange with server %v via %s: %v, next server: %s",
-			qname, server, dialer.Addr(), err, newServer)
+	if first != nil {
+		return first.server, first.respBytes, nil
+	}
+	return "", nil, err
+}
 
-		server = newServer
+// validResponse reports whether the response is valid, i.e., not SERVFAIL or REFUSED.
+func validResponse(respBytes []byte) bool {
+	if len(respBytes) < HeaderLen {
+		return false
 	}
+	rcode := respBytes[3] & 0xf
+	return rcode == RcodeSuccess || rcode == RcodeNXDomain
+}
 
-	// if all dns upstreams failed, then maybe the forwarder is not available.
+// exchangeConn connects to server with dialer on the network and exchanges with it.
+func (c *Client) exchangeConn(dialer proxy.Dialer, network, server string, reqBytes []byte) ([]byte, error) {
+	rc, err := dialer.Dial(network, server)
 	if err != nil {
-		c.proxy.Record(dialer, false)
+		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
+	defer rc.Close()
 
-	return server, network, dialer.Addr(), respBytes, err
+	// TODO: support timeout setting for different upstream server
+	if c.config.Timeout > 0 {
+		rc.SetDeadline(time.Now().Add(time.Duration(c.config.Timeout) * time.Second))
+	}
+
+	if network == "udp" {
+		return c.exchangeUDP(rc, reqBytes)
+	}
+	return c.exchangeTCP(rc, reqBytes)
 }
 
 // exchangeTCP exchange with server over tcp.
@@ -263,11 +531,29 @@ func (c *Client) exchangeUDP(rc net.Conn, reqBytes []byte) ([]byte, error) {
 
 // SetServers sets upstream dns servers for the given domain.
 func (c *Client) SetServers(domain string, servers []string) {
+	c.upStreamMu.Lock()
+	defer c.upStreamMu.Unlock()
 	c.upStreamMap[strings.ToLower(domain)] = NewUPStream(servers)
 }
 
+// ResetServers replaces all the domain specific upstream dns servers with
+// domainServers, which is a map of domain to its upstream dns servers.
+func (c *Client) ResetServers(domainServers map[string][]string) {
+	upStreamMap := make(map[string]*UPStream, len(domainServers))
+	for domain, servers := range domainServers {
+		upStreamMap[strings.ToLower(domain)] = NewUPStream(servers)
+	}
+
+	c.upStreamMu.Lock()
+	defer c.upStreamMu.Unlock()
+	c.upStreamMap = upStreamMap
+}
+
 // UpStream returns upstream dns server for the given domain.
 func (c *Client) UpStream(domain string) *UPStream {
+	c.upStreamMu.RLock()
+	defer c.upStreamMu.RUnlock()
+
 	domain = strings.ToLower(domain)
 	for i := len(domain); i != -1; {
 		i = strings.LastIndexByte(domain[:i], '.')
@@ -283,30 +569,56 @@ func (c *Client) AddHandler(h AnswerHandler) {
 	c.handlers = append(c.handlers, h)
 }
 
-// AddRecord adds custom record to dns cache, format:
-// www.example.com/1.2.3.4 or www.example.com/2606:2800:220:1:248:1893:25c8:1946
-func (c *Client) AddRecord(record string) error {
-	domain, ip, found := strings.Cut(record, "/")
-	if !found {
-		return errors.New("wrong record format, must contain '/'")
+// ReplayAnswers calls the answer handlers with the A and AAAA answers in cache,
+// it's used to rebuild the handlers' states, e.g. after rules reloaded.
+func (c *Client) ReplayAnswers() {
+	c.cache.Range(func(_ string, v []byte) {
+		if resp, err := UnmarshalMessage(v); err == nil {
+			c.extractAnswer(resp)
+		}
+	})
+}
+
+// SaveCache saves the dns cache to file.
+func (c *Client) SaveCache(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
 	}
-	m, err := MakeResponse(domain, ip, uint32(c.config.MaxTTL))
+
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".tmp")
 	if err != nil {
-		log.F("[dns] add custom record error: %s", err)
 		return err
 	}
+	defer os.Remove(f.Name())
 
-	wb := pool.GetBytesBuffer()
-	defer pool.PutBytesBuffer(wb)
+	if err := c.cache.SaveTo(f); err != nil {
+		f.Close()
+		return err
+	}
 
-	_, err = m.MarshalTo(wb)
-	if err != nil {
+	if err := f.Close(); err != nil {
 		return err
 	}
 
-	c.cache.Set(qKey(m.Question), valCopy(wb.Bytes()), 0)
+	return os.Rename(f.Name(), file)
+}
 
-	return nil
+// LoadCache loads the dns cache saved by SaveCache, the answers handlers are called
+// with the loaded answers, so they should be added before loading.
+func (c *Client) LoadCache(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := c.cache.LoadFrom(f)
+	if err != nil {
+		return 0, err
+	}
+
+	c.ReplayAnswers()
+	return n, nil
 }
 
 // MakeResponse makes a dns response message for the given domain and ip address.
@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nadoo/glider/pkg/pool"
+	"github.com/nadoo/glider/proxy"
+)
+
+// dohUpstream is a DNS-over-HTTPS upstream server, RFC 8484.
+type dohUpstream struct {
+	url    string
+	host   string // hostname of the server
+	get    bool   // use GET method instead of POST
+	client *http.Client
+}
+
+// isDoHServer reports whether server is a DNS-over-HTTPS url.
+func isDoHServer(server string) bool {
+	return strings.HasPrefix(server, "https://")
+}
+
+// dohUpstream returns the DoH upstream of server via dialer, format: https://HOST[:PORT]/PATH[#method=GET],
+// the connections are dialed through dialer and kept alive for reuse.
+func (c *Client) dohUpstream(dialer proxy.Dialer, server string) (*dohUpstream, error) {
+	key := dialer.Addr() + "|" + server
+	if d, ok := c.dohs.Load(key); ok {
+		return d.(*dohUpstream), nil
+	}
+
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid doh server: %s", server)
+	}
+
+	params, _ := url.ParseQuery(u.Fragment)
+	u.Fragment = ""
+
+	var timeout time.Duration
+	if c.config.Timeout > 0 {
+		timeout = time.Duration(c.config.Timeout) * time.Second
+	}
+
+	d := &dohUpstream{
+		url:  u.String(),
+		host: strings.ToLower(u.Hostname()),
+		get:  strings.EqualFold(params.Get("method"), http.MethodGet),
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return dialer.Dial(network, addr)
+				},
+				ForceAttemptHTTP2:   true,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+		},
+	}
+
+	v, _ := c.dohs.LoadOrStore(key, d)
+	return v.(*dohUpstream), nil
+}
+
+// exchangeDoH exchanges with the DoH server via dialer.
+func (c *Client) exchangeDoH(dialer proxy.Dialer, qname, server string, reqBytes []byte) ([]byte, error) {
+	d, err := c.dohUpstream(dialer, server)
+	if err != nil {
+		return nil, err
+	}
+
+	// the host of server can not be resolved by itself, use an ip in the url
+	// or set other dns servers to avoid it.
+	if strings.EqualFold(strings.TrimSuffix(qname, "."), d.host) {
+		return nil, errors.New("can not resolve the host of doh server by itself")
+	}
+
+	var req *http.Request
+	if d.get {
+		// use 0 as the message id so the responses can be cached by http caches.
+		msg := valCopy(reqBytes)
+		defer pool.PutBuffer(msg)
+		msg[0], msg[1] = 0, 0
+
+		sep := "?"
+		if strings.Contains(d.url, "?") {
+			sep = "&"
+		}
+		req, err = http.NewRequest(http.MethodGet, d.url+sep+"dns="+base64.RawURLEncoding.EncodeToString(msg), nil)
+	} else {
+		req, err = http.NewRequest(http.MethodPost, d.url, bytes.NewReader(reqBytes))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/dns-message")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < HeaderLen {
+		return nil, errors.New("invalid doh response: not enough data")
+	}
+
+	respBytes := pool.GetBuffer(len(b))
+	copy(respBytes, b)
+	copy(respBytes[:2], reqBytes[:2])
+
+	return respBytes, nil
+}
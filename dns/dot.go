@@ -0,0 +1,281 @@
+package dns
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nadoo/glider/pkg/pool"
+	"github.com/nadoo/glider/proxy"
+)
+
+// dotIdleTimeout is the time to keep an idle DNS-over-TLS connection.
+const dotIdleTimeout = 60 * time.Second
+
+// isDoTServer reports whether server is a DNS-over-TLS url.
+func isDoTServer(server string) bool {
+	return strings.HasPrefix(server, "tls://")
+}
+
+// dotConn is a persistent DNS-over-TLS connection, RFC 7858. queries are pipelined
+// on it, the message ids are rewritten to be unique in the connection.
+type dotConn struct {
+	net.Conn
+	wmu sync.Mutex // guards writing
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan []byte
+	err     error // the error which broke the connection
+}
+
+// exchangeDoT exchanges with the DoT server via dialer, a broken connection
+// reused from the pool is retried once with a new connection.
+func (c *Client) exchangeDoT(dialer proxy.Dialer, server string, reqBytes []byte) ([]byte, error) {
+	timeout := time.Duration(c.config.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = dotIdleTimeout
+	}
+
+	for {
+		dc, fresh, err := c.dotConn(dialer, server, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		respBytes, err := dc.exchange(reqBytes, timeout)
+		if err == nil || fresh || !dc.broken() {
+			return respBytes, err
+		}
+	}
+}
+
+// dotDial is a DNS-over-TLS connection being dialed, the concurrent queries wait for it.
+type dotDial struct {
+	done chan struct{}
+	dc   *dotConn
+	err  error
+}
+
+// dotConn returns the pooled connection to server via dialer, or dials a new one,
+// only one connection is dialed at a time for the same dialer and server.
+func (c *Client) dotConn(dialer proxy.Dialer, server string, timeout time.Duration) (dc *dotConn, fresh bool, err error) {
+	key := dialer.Addr() + "|" + server
+
+	c.dotMu.Lock()
+	if dc := c.dots[key]; dc != nil && !dc.broken() {
+		c.dotMu.Unlock()
+		return dc, false, nil
+	}
+
+	if d := c.dotDials[key]; d != nil {
+		c.dotMu.Unlock()
+		<-d.done
+		return d.dc, true, d.err
+	}
+
+	d := &dotDial{done: make(chan struct{})}
+	if c.dotDials == nil {
+		c.dotDials = make(map[string]*dotDial)
+	}
+	c.dotDials[key] = d
+	c.dotMu.Unlock()
+
+	d.dc, d.err = dialDoT(dialer, server, timeout)
+
+	c.dotMu.Lock()
+	delete(c.dotDials, key)
+	if d.err == nil {
+		if c.dots == nil {
+			c.dots = make(map[string]*dotConn)
+		}
+		c.dots[key] = d.dc
+	}
+	c.dotMu.Unlock()
+	close(d.done)
+
+	if d.err != nil {
+		return nil, false, d.err
+	}
+
+	go func() {
+		d.dc.readLoop()
+
+		c.dotMu.Lock()
+		if c.dots[key] == d.dc {
+			delete(c.dots, key)
+		}
+		c.dotMu.Unlock()
+	}()
+
+	return d.dc, true, nil
+}
+
+// dialDoT dials to the DoT server via dialer and completes the tls handshake.
+func dialDoT(dialer proxy.Dialer, server string, timeout time.Duration) (*dotConn, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid dot server: %s", server)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "853")
+	}
+
+	serverName := u.Query().Get("serverName")
+	if serverName == "" {
+		serverName = u.Hostname()
+	}
+
+	rc, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	tc := tls.Client(rc, &tls.Config{ServerName: serverName})
+	tc.SetDeadline(time.Now().Add(timeout))
+	if err := tc.Handshake(); err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+	tc.SetDeadline(time.Time{})
+
+	return &dotConn{Conn: tc, pending: make(map[uint16]chan []byte)}, nil
+}
+
+// exchange sends the request and waits for the response with the same id.
+func (dc *dotConn) exchange(reqBytes []byte, timeout time.Duration) ([]byte, error) {
+	if len(reqBytes) < HeaderLen {
+		return nil, errors.New("invalid request: not enough data")
+	}
+
+	ch := make(chan []byte, 1)
+
+	dc.mu.Lock()
+	if dc.err != nil {
+		dc.mu.Unlock()
+		return nil, dc.err
+	}
+	id := dc.nextID
+	for _, ok := dc.pending[id]; ok; _, ok = dc.pending[id] {
+		id++
+	}
+	dc.nextID = id + 1
+	dc.pending[id] = ch
+	dc.mu.Unlock()
+
+	defer func() {
+		dc.mu.Lock()
+		delete(dc.pending, id)
+		dc.mu.Unlock()
+
+		// the response may be dispatched after the timeout, put its buffer back to the pool.
+		select {
+		case respBytes, ok := <-ch:
+			if ok {
+				pool.PutBuffer(respBytes)
+			}
+		default:
+		}
+	}()
+
+	msg := pool.GetBuffer(2 + len(reqBytes))
+	defer pool.PutBuffer(msg)
+
+	binary.BigEndian.PutUint16(msg, uint16(len(reqBytes)))
+	copy(msg[2:], reqBytes)
+	binary.BigEndian.PutUint16(msg[2:], id)
+
+	dc.wmu.Lock()
+	dc.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := dc.Write(msg)
+	dc.wmu.Unlock()
+	if err != nil {
+		dc.close(err)
+		return nil, err
+	}
+
+	select {
+	case respBytes, ok := <-ch:
+		if !ok {
+			dc.mu.Lock()
+			defer dc.mu.Unlock()
+			return nil, dc.err
+		}
+		copy(respBytes[:2], reqBytes[:2])
+		return respBytes, nil
+	case <-time.After(timeout):
+		return nil, errors.New("dot query timeout")
+	}
+}
+
+// readLoop reads the responses and dispatches them by id until the connection is broken or idle.
+func (dc *dotConn) readLoop() {
+	lenBuf := make([]byte, 2)
+	for {
+		dc.SetReadDeadline(time.Now().Add(dotIdleTimeout))
+
+		if _, err := io.ReadFull(dc.Conn, lenBuf); err != nil {
+			dc.close(err)
+			return
+		}
+
+		respLen := int(binary.BigEndian.Uint16(lenBuf))
+		if respLen < HeaderLen {
+			dc.close(errors.New("invalid dot response: not enough data"))
+			return
+		}
+
+		respBytes := pool.GetBuffer(respLen)
+		if _, err := io.ReadFull(dc.Conn, respBytes); err != nil {
+			pool.PutBuffer(respBytes)
+			dc.close(err)
+			return
+		}
+
+		id := binary.BigEndian.Uint16(respBytes)
+		// the response is sent with the lock held, so it's either received by exchange
+		// or drained after exchange removes the pending id.
+		dc.mu.Lock()
+		ch, ok := dc.pending[id]
+		delete(dc.pending, id)
+		if ok {
+			ch <- respBytes // buffered, never blocks
+		}
+		dc.mu.Unlock()
+
+		if !ok {
+			pool.PutBuffer(respBytes)
+		}
+	}
+}
+
+// broken reports whether the connection is broken.
+func (dc *dotConn) broken() bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.err != nil
+}
+
+// close closes the connection with err, the pending queries are notified.
+func (dc *dotConn) close(err error) {
+	dc.mu.Lock()
+	if dc.err == nil {
+		dc.err = err
+		for id, ch := range dc.pending {
+			close(ch)
+			delete(dc.pending, id)
+		}
+	}
+	dc.mu.Unlock()
+
+	dc.Conn.Close()
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math/rand/v2"
 	"net/netip"
+	"strconv"
 	"strings"
 )
 
@@ -28,8 +30,42 @@ const (
 
 // Query types.
 const (
-	QTypeA    uint16 = 1  //ipv4
-	QTypeAAAA uint16 = 28 ///ipv6
+	QTypeA     uint16 = 1  //ipv4
+	QTypeNS    uint16 = 2  // name server
+	QTypeCNAME uint16 = 5  // canonical name
+	QTypeSOA   uint16 = 6  // start of authority
+	QTypePTR   uint16 = 12 // domain name pointer
+	QTypeMX    uint16 = 15 // mail exchange
+	QTypeTXT   uint16 = 16 // text strings
+	QTypeAAAA  uint16 = 28 ///ipv6
+	QTypeSRV   uint16 = 33 // service locator
+	QTypeSVCB  uint16 = 64 // service binding
+	QTypeHTTPS uint16 = 65 // https service binding
+)
+
+var qTypes = map[string]uint16{
+	"A": QTypeA, "NS": QTypeNS, "CNAME": QTypeCNAME, "SOA": QTypeSOA, "PTR": QTypePTR, "MX": QTypeMX,
+	"TXT": QTypeTXT, "AAAA": QTypeAAAA, "SRV": QTypeSRV, "SVCB": QTypeSVCB, "HTTPS": QTypeHTTPS,
+}
+
+// ParseQType parses the query type by name, e.g. MX, or by number, e.g. 15 or TYPE15.
+func ParseQType(s string) (uint16, error) {
+	s = strings.ToUpper(s)
+	if qtype, ok := qTypes[s]; ok {
+		return qtype, nil
+	}
+
+	qtype, err := strconv.ParseUint(strings.TrimPrefix(s, "TYPE"), 10, 16)
+	if err != nil || qtype == 0 {
+		return 0, errors.New("unknown query type: " + s)
+	}
+	return uint16(qtype), nil
+}
+
+// Response codes.
+const (
+	RcodeSuccess  = 0
+	RcodeNXDomain = 3
 )
 
 // ClassINET .
@@ -60,7 +96,8 @@ type Message struct {
 	Additional []*RR
 
 	// used in UnmarshalMessage
-	unMarshaled []byte
+	unMarshaled  []byte
+	authorityIdx int // offset of the authority section in unMarshaled
 }
 
 // NewMessage returns a new message.
@@ -158,10 +195,33 @@ func UnmarshalMessage(b []byte) (*Message, error) {
 	}
 
 	m.Header.SetAncount(len(m.Answers))
+	m.authorityIdx = rrIdx
 
 	return m, nil
 }
 
+// SOA returns the SOA rr in the authority section of the unmarshaled message, it's used
+// to get the ttl of negative answers, the authority section is parsed until any error.
+func (m *Message) SOA() (*RR, bool) {
+	rrIdx := m.authorityIdx
+	if rrIdx == 0 {
+		return nil, false
+	}
+
+	for range int(m.Header.NSCOUNT) {
+		rr := &RR{}
+		rrLen, err := m.UnmarshalRR(rrIdx, rr)
+		if err != nil {
+			return nil, false
+		}
+		if rr.TYPE == QTypeSOA {
+			return rr, true
+		}
+		rrIdx += rrLen
+	}
+	return nil, false
+}
+
 // Header format:
 // https://www.rfc-editor.org/rfc/rfc1035#section-4.1.1
 // The header contains the following fields:
@@ -195,6 +255,16 @@ func (h *Header) SetMsgType(qr MsgType) {
 	h.Bits |= uint16(qr) << 15
 }
 
+// SetRcode sets the response code.
+func (h *Header) SetRcode(rcode int) {
+	h.Bits = h.Bits&^0xf | uint16(rcode)&0xf
+}
+
+// Rcode returns the response code.
+func (h *Header) Rcode() int {
+	return int(h.Bits & 0xf)
+}
+
 // SetTC sets the tc flag.
 func (h *Header) SetTC(tc int) {
 	h.Bits |= uint16(tc) << 9
@@ -429,9 +499,9 @@ func (m *Message) UnmarshalRR(start int, rr *RR) (n int, err error) {
 
 	rr.RDATA = p[n+10 : n+10+int(rr.RDLENGTH)]
 
-	if rr.TYPE == QTypeA {
+	if rr.TYPE == QTypeA && rr.RDLENGTH == 4 {
 		rr.IP = netip.AddrFrom4(*(*[4]byte)(rr.RDATA[:4]))
-	} else if rr.TYPE == QTypeAAAA {
+	} else if rr.TYPE == QTypeAAAA && rr.RDLENGTH == 16 {
 		rr.IP = netip.AddrFrom16(*(*[16]byte)(rr.RDATA[:16]))
 	}
 
@@ -440,6 +510,72 @@ func (m *Message) UnmarshalRR(start int, rr *RR) (n int, err error) {
 	return n, nil
 }
 
+// SOAMinimum returns the MINIMUM field of a SOA rr, it's the ttl of negative answers, RFC 2308.
+func (rr *RR) SOAMinimum() (uint32, bool) {
+	// MNAME and RNAME are followed by 5 fixed 32 bit fields, MINIMUM is the last one
+	if rr.TYPE != QTypeSOA || len(rr.RDATA) < 22 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(rr.RDATA[len(rr.RDATA)-4:]), true
+}
+
+// MarshalDomain marshals domain to []byte without compression, it's used in RDATA.
+func MarshalDomain(domain string) []byte {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" { // root domain
+		return []byte{0x00}
+	}
+
+	buf := &bytes.Buffer{}
+	MarshalDomainTo(buf, domain)
+	return buf.Bytes()
+}
+
+// MarshalTXT marshals txt to the RDATA of TXT rr, long text is split into character strings of 255 bytes.
+func MarshalTXT(txt string) []byte {
+	buf := &bytes.Buffer{}
+	for {
+		n := min(len(txt), 255)
+		buf.WriteByte(byte(n))
+		buf.WriteString(txt[:n])
+		if txt = txt[n:]; txt == "" {
+			break
+		}
+	}
+	return buf.Bytes()
+}
+
+// MarshalSRV marshals the RDATA of SRV rr, RFC 2782.
+func MarshalSRV(priority, weight, port uint16, target string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, priority)
+	b = binary.BigEndian.AppendUint16(b, weight)
+	b = binary.BigEndian.AppendUint16(b, port)
+	return append(b, MarshalDomain(target)...)
+}
+
+// ReverseName returns the name of PTR query for ip, e.g. 4.3.2.1.in-addr.arpa for 1.2.3.4.
+func ReverseName(ip netip.Addr) string {
+	sb := new(strings.Builder)
+	b := ip.Unmap().AsSlice()
+	if len(b) == 4 {
+		for i := len(b) - 1; i >= 0; i-- {
+			fmt.Fprintf(sb, "%d.", b[i])
+		}
+		sb.WriteString("in-addr.arpa")
+		return sb.String()
+	}
+
+	const hex = "0123456789abcdef"
+	for i := len(b) - 1; i >= 0; i-- {
+		sb.WriteByte(hex[b[i]&0xf])
+		sb.WriteByte('.')
+		sb.WriteByte(hex[b[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa")
+	return sb.String()
+}
+
 // MarshalDomainTo marshals domain string struct to []byte and write to w.
 func MarshalDomainTo(w io.Writer, domain string) (n int, err error) {
 	nn := 0
@@ -0,0 +1,406 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nadoo/glider/pkg/log"
+	"github.com/nadoo/glider/pkg/pool"
+)
+
+// hostTTL is the ttl of the host records added by AddHost.
+const hostTTL = 60
+
+// maxCNAMEChain is the max number of CNAME records to follow in local records.
+const maxCNAMEChain = 8
+
+// localTypes are the query types answered locally for the names defined in records,
+// queries of the other types are forwarded to upstream servers.
+var localTypes = map[uint16]bool{
+	QTypeA: true, QTypeAAAA: true, QTypeCNAME: true, QTypeTXT: true, QTypeSRV: true, QTypePTR: true,
+}
+
+// Records is a set of local dns records, the names started with `*.` are
+// wildcards which match all the subdomains not defined in records.
+type Records struct {
+	mu       sync.RWMutex
+	full     map[string][]*record
+	wildcard map[string][]*record // by the domain after `*.`
+}
+
+// record is a local dns record.
+type record struct {
+	*RR
+	target string // the target domain of CNAME
+}
+
+// answer returns the rr of record with name.
+func (r *record) answer(name string) *RR {
+	rr := *r.RR
+	rr.NAME = name
+	return &rr
+}
+
+// add adds records, the PTR records are added for the A and AAAA records not in wildcard.
+func (rs *Records) add(recs ...*record) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, rec := range recs {
+		name := strings.ToLower(strings.TrimSuffix(rec.NAME, "."))
+		m := &rs.full
+		if domain, ok := strings.CutPrefix(name, "*."); ok {
+			name, m = domain, &rs.wildcard
+		} else if rec.IP.IsValid() {
+			ptr := MarshalDomain(name)
+			rs.addTo(&rs.full, ReverseName(rec.IP), &record{RR: &RR{NAME: ReverseName(rec.IP), TYPE: QTypePTR,
+				CLASS: ClassINET, TTL: rec.TTL, RDLENGTH: uint16(len(ptr)), RDATA: ptr}})
+		}
+		rs.addTo(m, name, rec)
+	}
+}
+
+func (rs *Records) addTo(m *map[string][]*record, name string, rec *record) {
+	if *m == nil {
+		*m = make(map[string][]*record)
+	}
+	for _, r := range (*m)[name] {
+		if r.TYPE == rec.TYPE && bytes.Equal(r.RDATA, rec.RDATA) {
+			return
+		}
+	}
+	(*m)[name] = append((*m)[name], rec)
+}
+
+// remove removes the A or AAAA record of name with ip and its PTR record.
+func (rs *Records) remove(name string, ip netip.Addr) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	ptr := MarshalDomain(name)
+	rs.removeFrom(name, func(r *record) bool { return r.IP == ip })
+	rs.removeFrom(ReverseName(ip), func(r *record) bool { return r.TYPE == QTypePTR && bytes.Equal(r.RDATA, ptr) })
+}
+
+// removeFrom removes the records of name matched by f, the slice is copied as it may be in use by lookup.
+func (rs *Records) removeFrom(name string, f func(*record) bool) {
+	recs := slices.DeleteFunc(slices.Clone(rs.full[name]), f)
+	if len(recs) == 0 {
+		delete(rs.full, name)
+		return
+	}
+	rs.full[name] = recs
+}
+
+// lookup returns the records of name, the wildcard records are matched if the name is not defined.
+func (rs *Records) lookup(name string) []*record {
+	if rs == nil {
+		return nil
+	}
+
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if recs, ok := rs.full[name]; ok {
+		return recs
+	}
+
+	for i := strings.IndexByte(name, '.'); i != -1; i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+		if recs, ok := rs.wildcard[name]; ok {
+			return recs
+		}
+	}
+	return nil
+}
+
+// parseRecord parses the custom record, format:
+//
+//	NAME/IP[,IP...]: A or AAAA records
+//	NAME/A/IP[,IP...] or NAME/AAAA/IP[,IP...]
+//	NAME/CNAME/TARGET
+//	NAME/TXT/TEXT
+//	NAME/SRV/PRIORITY WEIGHT PORT TARGET
+//	NAME/PTR/TARGET
+//
+// NAME can be a wildcard like *.example.com.
+func parseRecord(s string, ttl uint32) ([]*record, error) {
+	name, value, found := strings.Cut(s, "/")
+	if !found {
+		return nil, errors.New("wrong record format, must contain '/'")
+	}
+
+	if name = strings.TrimSpace(name); name == "" {
+		return nil, errors.New("empty record name")
+	}
+
+	qtype := QTypeA
+	if t, v, found := strings.Cut(value, "/"); found {
+		var err error
+		if qtype, err = ParseQType(t); err != nil || !localTypes[qtype] {
+			return nil, fmt.Errorf("unsupported record type: %s", t)
+		}
+		value = v
+	}
+
+	newRecord := func(qtype uint16, rdata []byte) *record {
+		return &record{RR: &RR{NAME: name, TYPE: qtype, CLASS: ClassINET,
+			TTL: ttl, RDLENGTH: uint16(len(rdata)), RDATA: rdata}}
+	}
+
+	switch qtype {
+	case QTypeA, QTypeAAAA:
+		var recs []*record
+		for _, s := range strings.Split(value, ",") {
+			ip, err := netip.ParseAddr(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			ip = ip.Unmap()
+
+			rec := newRecord(QTypeA, ip.AsSlice())
+			if ip.Is6() {
+				rec.TYPE = QTypeAAAA
+			}
+			rec.IP = ip
+			recs = append(recs, rec)
+		}
+		return recs, nil
+
+	case QTypeCNAME, QTypePTR:
+		target := strings.TrimSuffix(strings.TrimSpace(value), ".")
+		if !isDomain(target) {
+			return nil, fmt.Errorf("invalid target domain: %s", value)
+		}
+		rec := newRecord(qtype, MarshalDomain(target))
+		rec.target = target
+		return []*record{rec}, nil
+
+	case QTypeTXT:
+		if len(value) > 4096 {
+			return nil, errors.New("txt record too long")
+		}
+		return []*record{newRecord(QTypeTXT, MarshalTXT(value))}, nil
+
+	case QTypeSRV:
+		fields := strings.Fields(value)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("wrong srv record format: %s", value)
+		}
+
+		var nums [3]uint16
+		for i := range nums {
+			n, err := strconv.ParseUint(fields[i], 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("wrong srv record format: %s", value)
+			}
+			nums[i] = uint16(n)
+		}
+		return []*record{newRecord(QTypeSRV, MarshalSRV(nums[0], nums[1], nums[2], fields[3]))}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported record type: %d", qtype)
+}
+
+// parseHosts parses the content of hosts file, each line is in hosts
+// format `IP NAME [NAME...]` or the format of custom record.
+func parseHosts(content []byte, file string, ttl uint32) []*record {
+	var recs []*record
+
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil || len(fields) < 2 {
+			rs, err := parseRecord(line, ttl)
+			if err != nil {
+				log.Warn("[dns] invalid line in hosts file", "file", file, "line", lineNo, "error", err.Error())
+				continue
+			}
+			recs = append(recs, rs...)
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			rs, err := parseRecord(name+"/"+ip.String(), ttl)
+			if err != nil {
+				log.Warn("[dns] invalid line in hosts file", "file", file, "line", lineNo, "error", err.Error())
+				continue
+			}
+			recs = append(recs, rs...)
+		}
+	}
+
+	return recs
+}
+
+// SetRecords replaces the custom records with records and the ones in hosts files.
+func (c *Client) SetRecords(records, hostsFiles []string) {
+	rs := &Records{}
+	ttl := uint32(max(c.config.MaxTTL, 0))
+
+	for _, record := range records {
+		recs, err := parseRecord(record, ttl)
+		if err != nil {
+			log.Warn("[dns] invalid custom record", "record", record, "error", err.Error())
+			continue
+		}
+		rs.add(recs...)
+	}
+
+	for _, file := range hostsFiles {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			log.Warn("[dns] failed to read hosts file", "file", file, "error", err.Error())
+			continue
+		}
+		rs.add(parseHosts(content, file, ttl)...)
+	}
+
+	c.records.Store(rs)
+}
+
+// AddRecord adds custom record, see parseRecord for the format, e.g.
+// www.example.com/1.2.3.4, *.dev.lan/10.0.0.5, www.example.com/CNAME/example.com
+func (c *Client) AddRecord(record string) error {
+	recs, err := parseRecord(record, uint32(max(c.config.MaxTTL, 0)))
+	if err != nil {
+		return err
+	}
+
+	c.records.Load().add(recs...)
+	return nil
+}
+
+// AddHost adds the A or AAAA record of host name with ip and its PTR record,
+// e.g. the hostnames of dhcp clients. they are not replaced by SetRecords.
+func (c *Client) AddHost(name string, ip netip.Addr) {
+	recs, err := parseRecord(name+"/"+ip.String(), hostTTL)
+	if err != nil {
+		log.Warn("[dns] invalid host", "name", name, "ip", ip.String(), "error", err.Error())
+		return
+	}
+	c.hosts.add(recs...)
+	log.Debug("[dns] host added", "name", name, "ip", ip.String())
+}
+
+// RemoveHost removes the host record added by AddHost.
+func (c *Client) RemoveHost(name string, ip netip.Addr) {
+	c.hosts.remove(name, ip.Unmap())
+	log.Debug("[dns] host removed", "name", name, "ip", ip.String())
+}
+
+// lookup returns the records of name in custom records or hosts.
+func (c *Client) lookup(name string) []*record {
+	if recs := c.records.Load().lookup(name); recs != nil {
+		return recs
+	}
+	return c.hosts.lookup(name)
+}
+
+// localAnswer answers req with the local records, the CNAME records are followed
+// and the targets not in local records are resolved by upstream servers.
+func (c *Client) localAnswer(req *Message, preferTCP bool) ([]byte, bool) {
+	q := req.Question
+	recs := c.lookup(q.QNAME)
+	if recs == nil {
+		return nil, false
+	}
+
+	m := NewMessage(req.ID, ResponseMsg)
+	m.Bits |= req.Bits&(1<<8) | 1<<10 | 1<<7 // RD copied from request, AA, RA
+	m.SetQuestion(q)
+
+	name := q.QNAME
+	for range maxCNAMEChain {
+		var cname *record
+		for _, rec := range recs {
+			if rec.TYPE == q.QTYPE {
+				m.AddAnswer(rec.answer(name))
+			} else if rec.TYPE == QTypeCNAME {
+				cname = rec
+			}
+		}
+
+		if len(m.Answers) > 0 || cname == nil {
+			break
+		}
+
+		m.AddAnswer(cname.answer(name))
+		if name, recs = cname.target, c.lookup(cname.target); recs == nil {
+			m.Answers = append(m.Answers, c.resolve(name, q.QTYPE, preferTCP)...)
+			break
+		}
+	}
+
+	// the other query types of the names defined
+	if len(m.Answers) == 0 && !localTypes[q.QTYPE] {
+		return nil, false
+	}
+
+	for _, answer := range m.Answers {
+		if answer.IP.IsValid() {
+			for _, h := range c.handlers {
+				h(q.QNAME, answer.IP)
+			}
+		}
+	}
+
+	respBytes, err := m.Marshal()
+	if err != nil {
+		return nil, false
+	}
+	return respBytes, true
+}
+
+// resolve resolves qname of qtype, the answers of qtype are returned with name qname.
+// the answers whose RDATA may contain compressed domain names are ignored.
+func (c *Client) resolve(qname string, qtype uint16, preferTCP bool) (answers []*RR) {
+	switch qtype {
+	case QTypeNS, QTypeCNAME, QTypeSOA, QTypePTR, QTypeMX:
+		return nil
+	}
+
+	m := NewMessage(0, QueryMsg)
+	m.Bits |= 1 << 8 // RD
+	m.SetQuestion(NewQuestion(qtype, qname))
+
+	reqBytes, err := m.Marshal()
+	if err != nil {
+		return nil
+	}
+
+	respBytes, err := c.Exchange(reqBytes, "local", preferTCP)
+	if err != nil {
+		return nil
+	}
+	defer pool.PutBuffer(respBytes)
+
+	resp, err := UnmarshalMessage(respBytes)
+	if err != nil {
+		return nil
+	}
+
+	for _, rr := range resp.Answers {
+		if rr.TYPE == qtype {
+			rr.NAME, rr.RDATA = qname, bytes.Clone(rr.RDATA)
+			answers = append(answers, rr)
+		}
+	}
+	return answers
+}
@@ -2,8 +2,11 @@ package dns
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
+	"io/fs"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
@@ -15,11 +18,22 @@ import (
 // conn timeout, in seconds.
 const timeout = 30
 
+// cacheSaveInterval is the interval to save the cache to cache file.
+const cacheSaveInterval = 10 * time.Minute
+
 // Server is a dns server struct.
 type Server struct {
 	addr string
 	// Client is used to communicate with upstream dns servers
 	*Client
+
+	listeners proxy.Listeners
+	done      chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	doh      *http.Server          // the DoH server, closed with the server
+	dotConns map[net.Conn]struct{} // the DoT connections being served
 }
 
 // NewServer returns a new dns server.
@@ -32,6 +46,7 @@ func NewServer(addr string, p proxy.Proxy, config *Config) (*Server, error) {
 	s := &Server{
 		addr:   addr,
 		Client: c,
+		done:   make(chan struct{}),
 	}
 	return s, nil
 }
@@ -39,12 +54,89 @@ func NewServer(addr string, p proxy.Proxy, config *Config) (*Server, error) {
 // Start starts the dns forwarding server.
 // We use WaitGroup here to ensure both udp and tcp serer are completly running,
 // so we can start any other services later, since they may rely on dns service.
+// The plain server is not started if addr is empty, e.g. only DoH or DoT is served.
 func (s *Server) Start() {
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go s.ListenAndServeTCP(&wg)
-	go s.ListenAndServeUDP(&wg)
-	wg.Wait()
+	if s.addr != "" {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go s.ListenAndServeTCP(&wg)
+		go s.ListenAndServeUDP(&wg)
+		wg.Wait()
+	}
+
+	go s.refreshBlocklists()
+
+	if s.config.CacheFile != "" {
+		if n, err := s.LoadCache(s.config.CacheFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Warn("[dns] failed to load cache", "file", s.config.CacheFile, "error", err.Error())
+		} else if err == nil {
+			log.Info("[dns] cache loaded", "file", s.config.CacheFile, "entries", n)
+		}
+		go s.saveCache()
+	}
+}
+
+// Close closes the listeners of the dns server and saves the cache.
+func (s *Server) Close() error {
+	close(s.done)
+	err := s.listeners.Close()
+
+	s.mu.Lock()
+	s.closed = true
+	if s.doh != nil {
+		s.doh.Close()
+	}
+	for c := range s.dotConns {
+		c.Close()
+	}
+	s.dotConns = nil
+	s.mu.Unlock()
+
+	if s.config.CacheFile != "" {
+		if err := s.SaveCache(s.config.CacheFile); err != nil {
+			log.Warn("[dns] failed to save cache", "file", s.config.CacheFile, "error", err.Error())
+		}
+	}
+
+	return err
+}
+
+// saveCache saves the cache periodically until the server is closed.
+func (s *Server) saveCache() {
+	ticker := time.NewTicker(cacheSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.SaveCache(s.config.CacheFile); err != nil {
+				log.Warn("[dns] failed to save cache", "file", s.config.CacheFile, "error", err.Error())
+			}
+		}
+	}
+}
+
+// refreshBlocklists loads the blocklists and reloads them periodically until the server is closed.
+func (s *Server) refreshBlocklists() {
+	s.LoadBlocklists()
+
+	if s.config.BlockListInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.BlockListInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.LoadBlocklists()
+		}
+	}
 }
 
 // ListenAndServeUDP listen and serves on udp port.
@@ -52,19 +144,26 @@ func (s *Server) ListenAndServeUDP(wg *sync.WaitGroup) {
 	pc, err := net.ListenPacket("udp", s.addr)
 	wg.Done()
 	if err != nil {
-		log.F("[dns] failed to listen on %s, error: %v", s.addr, err)
+		log.Error("[dns] failed to listen", "listener", s.addr, "network", "udp", "error", err.Error())
 		return
 	}
 	defer pc.Close()
 
-	log.F("[dns] listening UDP on %s", s.addr)
+	if err := s.listeners.Add(pc); err != nil {
+		return
+	}
+
+	log.Info("[dns] listening", "listener", s.addr, "network", "udp")
 
 	for {
 		reqBytes := pool.GetBuffer(UDPMaxLen)
 		n, caddr, err := pc.ReadFrom(reqBytes)
 		if err != nil {
-			log.F("[dns] local read error: %v", err)
 			pool.PutBuffer(reqBytes)
+			if s.listeners.Closed() {
+				return
+			}
+			log.Debug("[dns] local read error", "error", err.Error())
 			continue
 		}
 		go s.ServePacket(pc, caddr, reqBytes[:n])
@@ -80,13 +179,13 @@ func (s *Server) ServePacket(pc net.PacketConn, caddr net.Addr, reqBytes []byte)
 	}()
 
 	if err != nil {
-		log.F("[dns] error in exchange for %s: %s", caddr, err)
+		log.Debug("[dns] error in exchange", "client", caddr.String(), "error", err.Error())
 		return
 	}
 
 	_, err = pc.WriteTo(respBytes, caddr)
 	if err != nil {
-		log.F("[dns] error in local write to %s: %s", caddr, err)
+		log.Debug("[dns] error in local write", "client", caddr.String(), "error", err.Error())
 		return
 	}
 }
@@ -96,17 +195,24 @@ func (s *Server) ListenAndServeTCP(wg *sync.WaitGroup) {
 	l, err := net.Listen("tcp", s.addr)
 	wg.Done()
 	if err != nil {
-		log.F("[dns-tcp] error: %v", err)
+		log.Error("[dns] failed to listen", "listener", s.addr, "network", "tcp", "error", err.Error())
 		return
 	}
 	defer l.Close()
 
-	log.F("[dns-tcp] listening TCP on %s", s.addr)
+	if err := s.listeners.Add(l); err != nil {
+		return
+	}
+
+	log.Info("[dns] listening", "listener", s.addr, "network", "tcp")
 
 	for {
 		c, err := l.Accept()
 		if err != nil {
-			log.F("[dns-tcp] error: failed to accept: %v", err)
+			if s.listeners.Closed() {
+				return
+			}
+			log.Debug("[dns-tcp] failed to accept", "error", err.Error())
 			continue
 		}
 		go s.ServeTCP(c)
@@ -121,7 +227,7 @@ func (s *Server) ServeTCP(c net.Conn) {
 
 	var reqLen uint16
 	if err := binary.Read(c, binary.BigEndian, &reqLen); err != nil {
-		log.F("[dns-tcp] failed to get request length: %v", err)
+		log.Debug("[dns-tcp] failed to get request length", "client", c.RemoteAddr().String(), "error", err.Error())
 		return
 	}
 
@@ -130,14 +236,14 @@ func (s *Server) ServeTCP(c net.Conn) {
 
 	_, err := io.ReadFull(c, reqBytes)
 	if err != nil {
-		log.F("[dns-tcp] error in read reqBytes %s", err)
+		log.Debug("[dns-tcp] error in read request", "client", c.RemoteAddr().String(), "error", err.Error())
 		return
 	}
 
 	respBytes, err := s.Exchange(reqBytes, c.RemoteAddr().String(), true)
 	defer pool.PutBuffer(respBytes)
 	if err != nil {
-		log.F("[dns-tcp] error in exchange: %s", err)
+		log.Debug("[dns-tcp] error in exchange", "client", c.RemoteAddr().String(), "error", err.Error())
 		return
 	}
 
@@ -146,6 +252,6 @@ func (s *Server) ServeTCP(c net.Conn) {
 
 	binary.BigEndian.PutUint16(lenBuf, uint16(len(respBytes)))
 	if _, err := (&net.Buffers{lenBuf, respBytes}).WriteTo(c); err != nil {
-		log.F("[dns-tcp] error in write respBytes: %s", err)
+		log.Debug("[dns-tcp] error in write response", "client", c.RemoteAddr().String(), "error", err.Error())
 	}
 }
@@ -0,0 +1,233 @@
+package dns
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nadoo/glider/pkg/log"
+	"github.com/nadoo/glider/pkg/pool"
+)
+
+// parseTLSListen parses the listen address of DoH and DoT servers,
+// format: [HOST]:PORT[/PATH]?cert=PATH&key=PATH, the path is used by DoH only.
+func parseTLSListen(s string) (addr, path string, config *tls.Config, err error) {
+	u, err := url.Parse("https://" + s)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	query := u.Query()
+	certFile, keyFile := query.Get("cert"), query.Get("key")
+	if certFile == "" || keyFile == "" {
+		return "", "", nil, errors.New("cert and key file path must be spcified")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	return u.Host, u.Path, &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// ListenAndServeDoH listens and serves DNS-over-HTTPS(RFC 8484) on listen,
+// format: [HOST]:PORT[/PATH]?cert=PATH&key=PATH, default path: /dns-query.
+func (s *Server) ListenAndServeDoH(listen string) {
+	addr, path, config, err := parseTLSListen(listen)
+	if err != nil {
+		log.Error("[dns-doh] invalid listen address", "listener", listen, "error", err.Error())
+		return
+	}
+	if path == "" || path == "/" {
+		path = "/dns-query"
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Error("[dns-doh] failed to listen", "listener", addr, "error", err.Error())
+		return
+	}
+
+	if err := s.listeners.Add(l); err != nil {
+		return
+	}
+
+	log.Info("[dns-doh] listening", "listener", addr, "path", path)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.ServeDoH)
+
+	srv := &http.Server{
+		Handler:     mux,
+		TLSConfig:   config,
+		ReadTimeout: time.Duration(timeout) * time.Second,
+		IdleTimeout: time.Duration(timeout) * time.Second,
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.doh = srv
+	s.mu.Unlock()
+
+	if err := srv.ServeTLS(l, "", ""); err != nil && !s.listeners.Closed() {
+		log.Error("[dns-doh] failed to serve", "listener", addr, "error", err.Error())
+	}
+}
+
+// ServeDoH serves a DoH request with GET or POST method.
+func (s *Server) ServeDoH(w http.ResponseWriter, r *http.Request) {
+	var reqBytes []byte
+	switch r.Method {
+	case http.MethodGet:
+		b, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil || len(b) < HeaderLen {
+			http.Error(w, "invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+		reqBytes = b
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		b, err := io.ReadAll(io.LimitReader(r.Body, 65535))
+		if err != nil || len(b) < HeaderLen {
+			http.Error(w, "invalid dns message", http.StatusBadRequest)
+			return
+		}
+		reqBytes = b
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respBytes, err := s.Exchange(reqBytes, r.RemoteAddr, true)
+	defer pool.PutBuffer(respBytes)
+	if err != nil {
+		log.Debug("[dns-doh] error in exchange", "client", r.RemoteAddr, "error", err.Error())
+		http.Error(w, "dns exchange error", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(respBytes)
+}
+
+// ListenAndServeDoT listens and serves DNS-over-TLS(RFC 7858) on listen,
+// format: [HOST]:PORT?cert=PATH&key=PATH.
+func (s *Server) ListenAndServeDoT(listen string) {
+	addr, _, config, err := parseTLSListen(listen)
+	if err != nil {
+		log.Error("[dns-dot] invalid listen address", "listener", listen, "error", err.Error())
+		return
+	}
+
+	l, err := tls.Listen("tcp", addr, config)
+	if err != nil {
+		log.Error("[dns-dot] failed to listen", "listener", addr, "error", err.Error())
+		return
+	}
+	defer l.Close()
+
+	if err := s.listeners.Add(l); err != nil {
+		return
+	}
+
+	log.Info("[dns-dot] listening", "listener", addr)
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
+			log.Debug("[dns-dot] failed to accept", "error", err.Error())
+			continue
+		}
+		go s.ServeDoT(c)
+	}
+}
+
+// ServeDoT serves a DoT connection, the queries on it are handled concurrently
+// and the connection is kept until it's idle for the timeout.
+func (s *Server) ServeDoT(c net.Conn) {
+	defer c.Close()
+
+	if !s.trackDoT(c) {
+		return
+	}
+	defer s.untrackDoT(c)
+
+	var wmu sync.Mutex
+	for {
+		c.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+
+		var reqLen uint16
+		if err := binary.Read(c, binary.BigEndian, &reqLen); err != nil {
+			return
+		}
+
+		reqBytes := pool.GetBuffer(int(reqLen))
+		if _, err := io.ReadFull(c, reqBytes); err != nil {
+			pool.PutBuffer(reqBytes)
+			log.Debug("[dns-dot] error in read request", "client", c.RemoteAddr().String(), "error", err.Error())
+			return
+		}
+
+		go func() {
+			defer pool.PutBuffer(reqBytes)
+
+			respBytes, err := s.Exchange(reqBytes, c.RemoteAddr().String(), true)
+			defer pool.PutBuffer(respBytes)
+			if err != nil {
+				log.Debug("[dns-dot] error in exchange", "client", c.RemoteAddr().String(), "error", err.Error())
+				return
+			}
+
+			lenBuf := pool.GetBuffer(2)
+			defer pool.PutBuffer(lenBuf)
+			binary.BigEndian.PutUint16(lenBuf, uint16(len(respBytes)))
+
+			wmu.Lock()
+			defer wmu.Unlock()
+			c.SetWriteDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+			if _, err := (&net.Buffers{lenBuf, respBytes}).WriteTo(c); err != nil {
+				log.Debug("[dns-dot] error in write response", "client", c.RemoteAddr().String(), "error", err.Error())
+			}
+		}()
+	}
+}
+
+// trackDoT adds the DoT connection c to be closed with the server,
+// it returns false if the server is already closed.
+func (s *Server) trackDoT(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if s.dotConns == nil {
+		s.dotConns = make(map[net.Conn]struct{})
+	}
+	s.dotConns[c] = struct{}{}
+	return true
+}
+
+// untrackDoT removes the DoT connection c.
+func (s *Server) untrackDoT(c net.Conn) {
+	s.mu.Lock()
+	delete(s.dotConns, c)
+	s.mu.Unlock()
+}
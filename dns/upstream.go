@@ -1,25 +1,54 @@
 package dns
 
 import (
+	"cmp"
 	"net"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// upstreamDownTime is the time to put a failed server after the others in fastest strategy.
+const upstreamDownTime = 30 * time.Second
+
+// upstreamStaleTime is the time after which the response time of a server is treated
+// as not measured, so the servers not used in fastest strategy are probed again.
+const upstreamStaleTime = 5 * time.Minute
+
 // UPStream is a dns upstream.
 type UPStream struct {
 	index   uint32
 	servers []string
+	stats   map[string]*serverStat
+}
+
+// serverStat is the response time statistics of a dns server.
+type serverStat struct {
+	mu        sync.Mutex
+	rtt       time.Duration // moving average of response time, 0 means not measured
+	updated   time.Time     // time of the last response
+	downUntil time.Time
 }
 
 // NewUPStream returns a new UpStream.
 func NewUPStream(servers []string) *UPStream {
-	// default port for dns upstream servers
+	// default port for dns upstream servers, urls are left as they are
 	for i, server := range servers {
+		if strings.Contains(server, "://") {
+			continue
+		}
 		if _, port, _ := net.SplitHostPort(server); port == "" {
 			servers[i] = net.JoinHostPort(server, "53")
 		}
 	}
-	return &UPStream{servers: servers}
+
+	stats := make(map[string]*serverStat, len(servers))
+	for _, server := range servers {
+		stats[server] = &serverStat{}
+	}
+	return &UPStream{servers: servers, stats: stats}
 }
 
 // Server returns a dns server.
@@ -44,3 +73,68 @@ func (u *UPStream) SwitchIf(server string) string {
 func (u *UPStream) Len() int {
 	return len(u.servers)
 }
+
+// Servers returns all the dns servers.
+func (u *UPStream) Servers() []string {
+	return u.servers
+}
+
+// Record records the response time of server, or the failure if !ok.
+func (u *UPStream) Record(server string, rtt time.Duration, ok bool) {
+	st := u.stats[server]
+	if st == nil {
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !ok {
+		st.downUntil = time.Now().Add(upstreamDownTime)
+		return
+	}
+
+	now := time.Now()
+	st.downUntil = time.Time{}
+	if st.rtt == 0 || now.Sub(st.updated) > upstreamStaleTime {
+		st.rtt = rtt
+	} else {
+		st.rtt = (st.rtt*7 + rtt) / 8
+	}
+	st.updated = now
+}
+
+// Sorted returns the dns servers sorted by response time, the servers not measured
+// or measured long ago are the first ones and the servers failed recently are the last ones.
+func (u *UPStream) Sorted() []string {
+	type stat struct {
+		down bool
+		rtt  time.Duration
+	}
+
+	now := time.Now()
+	stats := make(map[string]stat, len(u.servers))
+	for _, server := range u.servers {
+		st := u.stats[server]
+		st.mu.Lock()
+		rtt := st.rtt
+		if now.Sub(st.updated) > upstreamStaleTime {
+			rtt = 0
+		}
+		stats[server] = stat{down: now.Before(st.downUntil), rtt: rtt}
+		st.mu.Unlock()
+	}
+
+	servers := slices.Clone(u.servers)
+	slices.SortStableFunc(servers, func(a, b string) int {
+		sa, sb := stats[a], stats[b]
+		if sa.down != sb.down {
+			if sa.down {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(sa.rtt, sb.rtt)
+	})
+	return servers
+}
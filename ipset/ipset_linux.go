@@ -2,6 +2,7 @@ package ipset
 
 import (
 	"net/netip"
+	"slices"
 	"strings"
 	"sync"
 
@@ -12,7 +13,18 @@ import (
 
 // Manager struct.
 type Manager struct {
-	domainSet sync.Map
+	domains  *rule.DomainMatcher[string]
+	geoIPs   []*rule.Config // rules with geoip
+	ruleSets []*ruleSet
+}
+
+// ruleSet is a rule set and the ipset it belongs to.
+type ruleSet struct {
+	rs      *rule.RuleSet
+	setName string
+
+	mu  sync.Mutex
+	ips map[netip.Addr]string // ips added for the domains matched -> domain
 }
 
 // NewManager returns a Manager
@@ -21,7 +33,7 @@ func NewManager(rules []*rule.Config) (*Manager, error) {
 		return nil, err
 	}
 
-	m := &Manager{}
+	m := &Manager{domains: rule.NewDomainMatcher[string]()}
 	sets := make(map[string]struct{})
 
 	for _, r := range rules {
@@ -38,7 +50,16 @@ func NewManager(rules []*rule.Config) (*Manager, error) {
 		}
 
 		for _, domain := range r.Domain {
-			m.domainSet.Store(domain, r.IPSet)
+			m.domains.AddDomain(domain, r.IPSet)
+		}
+		for _, domain := range r.DomainFull {
+			m.domains.AddFull(domain, r.IPSet)
+		}
+		for _, keyword := range r.DomainKeyword {
+			m.domains.AddKeyword(keyword, r.IPSet)
+		}
+		for _, expr := range r.DomainRegex {
+			m.domains.AddRegexp(expr, r.IPSet)
 		}
 		for _, ip := range r.IP {
 			addToSet(r.IPSet, ip)
@@ -46,23 +67,106 @@ func NewManager(rules []*rule.Config) (*Manager, error) {
 		for _, cidr := range r.CIDR {
 			addToSet(r.IPSet, cidr)
 		}
+		if len(r.GeoIP) > 0 {
+			m.geoIPs = append(m.geoIPs, r)
+		}
+		for _, rs := range r.RuleSets() {
+			set := &ruleSet{rs: rs, setName: r.IPSet, ips: make(map[netip.Addr]string)}
+			m.ruleSets = append(m.ruleSets, set)
+			rs.AddHandler(func(old, new *rule.RuleList) {
+				updateSet(r.IPSet, old, new)
+				m.removeResolved(set, new)
+			})
+		}
 	}
+	m.domains.Compile()
 
 	return m, nil
 }
 
-// AddDomainIP implements the dns AnswerHandler function, used to update ipset according to domainSet rule.
+// AddDomainIP implements the dns AnswerHandler function, used to update ipset according to domain and geoip rules.
 func (m *Manager) AddDomainIP(domain string, ip netip.Addr) error {
-	domain = strings.ToLower(domain)
-	for i := len(domain); i != -1; {
-		i = strings.LastIndexByte(domain[:i], '.')
-		if setName, ok := m.domainSet.Load(domain[i+1:]); ok {
-			addAddrToSet(setName.(string), ip)
+	m.domains.Range(domain, func(setName string) bool {
+		addAddrToSet(setName, ip)
+		return true
+	})
+	for _, r := range m.geoIPs {
+		if r.MatchGeoIP(ip) {
+			addAddrToSet(r.IPSet, ip)
+		}
+	}
+	for _, r := range m.ruleSets {
+		if r.rs.List().MatchDomain(domain) {
+			addAddrToSet(r.setName, ip)
+			r.mu.Lock()
+			r.ips[ip] = strings.ToLower(domain)
+			r.mu.Unlock()
 		}
 	}
 	return nil
 }
 
+// removeResolved deletes the ips added for the domains of set which are not in the new list,
+// unless they are still needed by other rules of the same ipset.
+func (m *Manager) removeResolved(set *ruleSet, list *rule.RuleList) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for ip, domain := range set.ips {
+		if list.MatchDomain(domain) {
+			continue
+		}
+		delete(set.ips, ip)
+		if !list.MatchIP(ip) && !m.inSet(set, domain, ip) {
+			delAddrFromSet(set.setName, ip)
+		}
+	}
+}
+
+// inSet reports whether the other rules of the same ipset as set add domain or ip to it.
+func (m *Manager) inSet(set *ruleSet, domain string, ip netip.Addr) bool {
+	found := false
+	m.domains.Range(domain, func(setName string) bool {
+		found = setName == set.setName
+		return !found
+	})
+	if found {
+		return true
+	}
+	for _, r := range m.geoIPs {
+		if r.IPSet == set.setName && r.MatchGeoIP(ip) {
+			return true
+		}
+	}
+	for _, r := range m.ruleSets {
+		if r != set && r.setName == set.setName && (r.rs.List().MatchDomain(domain) || r.rs.List().MatchIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateSet replaces the ips and cidrs of the old rule list in set s with the new one.
+func updateSet(s string, old, new *rule.RuleList) {
+	items := make(map[string]bool) // item -> whether it's in the new list
+	if old != nil {
+		for _, item := range slices.Concat(old.IP, old.CIDR) {
+			items[item] = false
+		}
+	}
+	for _, item := range slices.Concat(new.IP, new.CIDR) {
+		if _, ok := items[item]; !ok {
+			addToSet(s, item)
+		}
+		items[item] = true
+	}
+	for item, ok := range items {
+		if !ok {
+			delFromSet(s, item)
+		}
+	}
+}
+
 func addToSet(s, item string) error {
 	if strings.IndexByte(item, '.') == -1 {
 		return ipset.Add(s+"6", item)
@@ -70,9 +174,23 @@ func addToSet(s, item string) error {
 	return ipset.Add(s, item)
 }
 
+func delFromSet(s, item string) error {
+	if strings.IndexByte(item, '.') == -1 {
+		return ipset.Del(s+"6", item)
+	}
+	return ipset.Del(s, item)
+}
+
 func addAddrToSet(s string, ip netip.Addr) error {
 	if ip.Is4() {
 		return ipset.AddAddr(s, ip)
 	}
 	return ipset.AddAddr(s+"6", ip)
 }
+
+func delAddrFromSet(s string, ip netip.Addr) error {
+	if ip.Is4() {
+		return ipset.DelAddr(s, ip)
+	}
+	return ipset.DelAddr(s+"6", ip)
+}
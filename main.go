@@ -2,14 +2,16 @@ package main
 
 import (
 	"context"
+	"maps"
 	"net"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
+	"github.com/nadoo/glider/api"
 	"github.com/nadoo/glider/dns"
-	"github.com/nadoo/glider/ipset"
 	"github.com/nadoo/glider/pkg/log"
 	"github.com/nadoo/glider/proxy"
 	"github.com/nadoo/glider/rule"
@@ -23,71 +25,146 @@ var (
 
 func main() {
 	// global rule proxy
-	pxy := rule.NewProxy(config.Forwards, &config.Strategy, config.rules)
+	p, err := rule.NewProxy(config.Forwards, config.ForwardSubs, &config.Strategy, config.rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pxy := newSwitchProxy(p)
+	proxy.SetBlockPage(config.blockPage)
+
+	// access log
+	var accessLog *proxy.AccessLog
+	if config.AccessLog != "" {
+		accessLog, err = proxy.OpenAccessLog(config.AccessLog)
+		if err != nil {
+			log.Fatal(err)
+		}
+		proxy.SetAccessLog(accessLog)
+	}
 
 	// ipset manager
-	ipsetM, _ := ipset.NewManager(config.rules)
+	ipsetM := newSwitchIPSet(config.rules)
 
 	// check and setup dns server
-	if config.DNS != "" {
-		d, err := dns.NewServer(config.DNS, pxy, &config.DNSConfig)
+	var d *dns.Server
+	if config.DNS != "" || config.DNSDoH != "" || config.DNSDoT != "" {
+		d, err = dns.NewServer(config.DNS, pxy, &config.DNSConfig)
 		if err != nil {
 			log.Fatal(err)
 		}
 
 		// rules
-		for _, r := range config.rules {
-			if len(r.DNSServers) > 0 {
-				for _, domain := range r.Domain {
-					d.SetServers(domain, r.DNSServers)
-				}
-			}
-		}
+		d.ResetServers(dnsServers(config.rules))
 
 		// add a handler to update proxy rules when a domain resolved
 		d.AddHandler(pxy.AddDomainIP)
-		if ipsetM != nil {
-			d.AddHandler(ipsetM.AddDomainIP)
-		}
+		d.AddHandler(ipsetM.AddDomainIP)
 
 		d.Start()
 
-		// custom resolver
+		// encrypted dns servers
+		if config.DNSDoH != "" {
+			go d.ListenAndServeDoH(config.DNSDoH)
+		}
+		if config.DNSDoT != "" {
+			go d.ListenAndServeDoT(config.DNSDoT)
+		}
+	}
+
+	// custom resolver
+	if config.DNS != "" {
+		dnsAddr := config.DNS
 		net.DefaultResolver = &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
 				d := net.Dialer{Timeout: time.Second * 3}
-				return d.DialContext(ctx, "udp", config.DNS)
+				return d.DialContext(ctx, "udp", dnsAddr)
 			},
 		}
 	}
 
 	for _, r := range config.rules {
 		r.IP, r.CIDR, r.Domain = nil, nil, nil
+		r.DomainFull, r.DomainKeyword, r.DomainRegex = nil, nil, nil
 	}
 
 	// enable checkers
-	pxy.Check()
+	p.Check()
+
+	// run api server
+	if config.API != "" {
+		go api.NewServer(config.API, config.APIToken, pxy).ListenAndServe()
+	}
 
 	// run proxy servers
+	servers := make(map[string]proxy.Server)
 	for _, listen := range config.Listens {
-		local, err := proxy.ServerFromURL(listen, pxy)
+		local, err := proxy.ServerFromURL(listen, proxy.WithListener(pxy, listen))
 		if err != nil {
 			log.Fatal(err)
 		}
+		servers[listen] = local
 		go local.ListenAndServe()
 	}
 
+	if err := proxy.WaitListen(slices.Collect(maps.Values(servers)), listenWait); err != nil {
+		log.Fatal(err)
+	}
+
 	// run services
+	if d != nil {
+		service.SetHosts(d)
+	}
+
+	var services []service.Service
 	for _, s := range config.Services {
 		service, err := service.New(s)
 		if err != nil {
 			log.Fatal(err)
 		}
+		services = append(services, service)
 		go service.Run()
 	}
 
+	r := &reloader{proxy: pxy, ipset: ipsetM, dns: d, servers: servers, accessLog: accessLog}
+
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		r.reload()
+	}
+
+	// graceful shutdown: stop accepting and wait for the active connections,
+	// send the signal again to exit immediately.
+	for _, local := range r.servers {
+		local.Close()
+	}
+
+	if d != nil {
+		d.Close()
+	}
+
+	for _, service := range services {
+		service.Stop()
+	}
+
+	timeout := time.Duration(config.ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	if n := proxy.ActiveSessions(); n > 0 {
+		log.Printf("[main] shutting down, waiting for %d active connections to finish", n)
+	}
+
+	if err := proxy.Shutdown(ctx); err != nil {
+		log.Printf("[main] shutdown: %v, closed the remaining connections", err)
+	}
 }
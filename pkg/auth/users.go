@@ -0,0 +1,276 @@
+// Package auth implements user authentication with a users file.
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nadoo/glider/pkg/log"
+)
+
+// checkInterval is the minimum interval to check whether the users file changed.
+const checkInterval = 5 * time.Second
+
+// argon2 parameter limits of the hashes in users file.
+const (
+	maxArgon2Memory = 1 << 20 // KiB, 1GiB
+	minArgon2Salt   = 8
+	minArgon2Key    = 16
+)
+
+// hashSlots limits the concurrent password hash comparisons, argon2 may use
+// lots of memory and cpu for each one, e.g. 64MB with m=65536.
+var hashSlots = make(chan struct{}, runtime.NumCPU())
+
+// Users is a set of users loaded from a users file, one user per line:
+//
+//	USERNAME:HASHED_PASSWORD
+//
+// the password can be hashed with bcrypt (e.g. `htpasswd -nB USERNAME`)
+// or argon2 in PHC string format: $argon2id$v=19$m=65536,t=3,p=4$SALT$HASH.
+// lines start with "#" are comments. The hashes are validated when the file is
+// loaded, a file with any invalid line is rejected. The file is reloaded when it changes.
+type Users struct {
+	path string
+
+	mu        sync.RWMutex
+	users     map[string]string              // username -> hashed password
+	verified  map[[sha256.Size]byte]struct{} // sha256(username:password) of verified users
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+// NewUsers loads the users file at path.
+func NewUsers(path string) (*Users, error) {
+	u := &Users{path: path}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return u, u.load(fi)
+}
+
+// load loads the users file with info fi.
+func (u *Users) load(fi os.FileInfo) error {
+	f, err := os.Open(u.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			return fmt.Errorf("%s:%d: invalid user line", u.path, n)
+		}
+
+		if err := checkHash(hash); err != nil {
+			return fmt.Errorf("%s:%d: invalid password hash of user %s: %w", u.path, n, name, err)
+		}
+		users[name] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	u.mu.Lock()
+	u.users, u.verified = users, make(map[[sha256.Size]byte]struct{})
+	u.modTime, u.size, u.checkedAt = fi.ModTime(), fi.Size(), time.Now()
+	u.mu.Unlock()
+
+	return nil
+}
+
+// reloadIfChanged reloads the users file if it's changed since last load,
+// the file is checked at most once every checkInterval.
+func (u *Users) reloadIfChanged() {
+	u.mu.Lock()
+	if time.Since(u.checkedAt) < checkInterval {
+		u.mu.Unlock()
+		return
+	}
+	u.checkedAt = time.Now()
+	modTime, size := u.modTime, u.size
+	u.mu.Unlock()
+
+	fi, err := os.Stat(u.path)
+	if err != nil {
+		log.Warn("[auth] stat users file error", "path", u.path, "error", err.Error())
+		return
+	}
+
+	if fi.ModTime().Equal(modTime) && fi.Size() == size {
+		return
+	}
+
+	if err := u.load(fi); err != nil {
+		log.Warn("[auth] reload users file error, keep the loaded users", "path", u.path, "error", err.Error())
+		return
+	}
+
+	u.mu.RLock()
+	n := len(u.users)
+	u.mu.RUnlock()
+	log.Info("[auth] reloaded users file", "path", u.path, "users", n)
+}
+
+// Verify reports whether the password of user is correct.
+func (u *Users) Verify(user, pass string) bool {
+	u.reloadIfChanged()
+
+	// the hash functions are slow by design, so remember the verified credentials.
+	key := sha256.Sum256([]byte(user + ":" + pass))
+
+	u.mu.RLock()
+	hash, ok := u.users[user]
+	_, verified := u.verified[key]
+	u.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	if verified {
+		return true
+	}
+
+	hashSlots <- struct{}{}
+	err := compareHash(hash, pass)
+	<-hashSlots
+	if err != nil {
+		return false
+	}
+
+	u.mu.Lock()
+	if u.users[user] == hash {
+		u.verified[key] = struct{}{}
+	}
+	u.mu.Unlock()
+
+	return true
+}
+
+var errMismatched = errors.New("hashed password mismatched")
+
+// checkHash checks whether hash is a valid bcrypt or argon2 hashed password.
+func checkHash(hash string) error {
+	if strings.HasPrefix(hash, "$2") {
+		_, err := bcrypt.Cost([]byte(hash))
+		return err
+	}
+
+	if strings.HasPrefix(hash, "$argon2") {
+		_, err := parseArgon2(hash)
+		return err
+	}
+
+	return errors.New("unsupported password hash")
+}
+
+// compareHash compares a bcrypt or argon2 hashed password with its possible plaintext equivalent.
+func compareHash(hash, pass string) error {
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass))
+	}
+
+	h, err := parseArgon2(hash)
+	if err != nil {
+		return err
+	}
+
+	var other []byte
+	switch h.variant {
+	case "argon2id":
+		other = argon2.IDKey([]byte(pass), h.salt, h.iterations, h.memory, h.threads, uint32(len(h.key)))
+	case "argon2i":
+		other = argon2.Key([]byte(pass), h.salt, h.iterations, h.memory, h.threads, uint32(len(h.key)))
+	}
+
+	if subtle.ConstantTimeCompare(h.key, other) != 1 {
+		return errMismatched
+	}
+
+	return nil
+}
+
+// argon2Hash is a parsed argon2 hashed password.
+type argon2Hash struct {
+	variant    string
+	memory     uint32
+	iterations uint32
+	threads    uint8
+	salt       []byte
+	key        []byte
+}
+
+// parseArgon2 parses and validates an argon2 hash in PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=4$SALT$HASH
+func parseArgon2(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2 hash")
+	}
+
+	h := &argon2Hash{variant: parts[1]}
+	if h.variant != "argon2id" && h.variant != "argon2i" {
+		return nil, errors.New("unsupported argon2 variant: " + h.variant)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.New("unsupported argon2 version")
+	}
+
+	var threads uint32
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2 params: %w", err)
+	}
+	if h.iterations < 1 {
+		return nil, errors.New("invalid argon2 params: t must be at least 1")
+	}
+	if threads < 1 || threads > 255 {
+		return nil, errors.New("invalid argon2 params: p must be between 1 and 255")
+	}
+	if h.memory > maxArgon2Memory {
+		return nil, fmt.Errorf("invalid argon2 params: m must be at most %d", maxArgon2Memory)
+	}
+	h.threads = uint8(threads)
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	if len(h.salt) < minArgon2Salt {
+		return nil, fmt.Errorf("invalid argon2 salt: at least %d bytes required", minArgon2Salt)
+	}
+
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("invalid argon2 hash: %w", err)
+	}
+	if len(h.key) < minArgon2Key {
+		return nil, fmt.Errorf("invalid argon2 hash: at least %d bytes required", minArgon2Key)
+	}
+
+	return h, nil
+}
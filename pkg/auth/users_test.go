@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// argon2PHC returns the argon2 hash of pass in PHC string format.
+func argon2PHC(variant, pass string, m, t uint32, p uint8) string {
+	salt := []byte("0123456789abcdef")
+	var key []byte
+	if variant == "argon2id" {
+		key = argon2.IDKey([]byte(pass), salt, t, m, p, 32)
+	} else {
+		key = argon2.Key([]byte(pass), salt, t, m, p, 32)
+	}
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", variant, argon2.Version, m, t, p,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// writeUsers writes lines to a users file and returns its path.
+func writeUsers(t *testing.T, lines ...string) string {
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestUsersVerify(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := NewUsers(writeUsers(t,
+		"# comment",
+		"",
+		"alice:"+string(bcryptHash),
+		"bob:"+argon2PHC("argon2id", "argon2id-pass", 64, 1, 1),
+		"carol:"+argon2PHC("argon2i", "argon2i-pass", 64, 2, 2),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		user, pass string
+		want       bool
+	}{
+		{"alice", "bcrypt-pass", true},
+		{"alice", "wrong", false},
+		{"bob", "argon2id-pass", true},
+		{"bob", "argon2id-pass", true}, // verified before
+		{"bob", "", false},
+		{"carol", "argon2i-pass", true},
+		{"carol", "argon2id-pass", false},
+		{"dave", "bcrypt-pass", false},
+	} {
+		if got := u.Verify(tt.user, tt.pass); got != tt.want {
+			t.Errorf("Verify(%q, %q) = %v, want %v", tt.user, tt.pass, got, tt.want)
+		}
+	}
+}
+
+func TestUsersInvalidHash(t *testing.T) {
+	salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	key := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+
+	for _, hash := range []string{
+		"plain",
+		"$2a$10$invalid",
+		"$argon2d$v=19$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key,
+		"$argon2id$v=19$m=4294967295,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$",
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$c2hvcnQ",
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!",
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt,
+	} {
+		if _, err := NewUsers(writeUsers(t, "user:"+hash)); err == nil {
+			t.Errorf("NewUsers with hash %q: no error", hash)
+		}
+	}
+
+	if _, err := NewUsers(writeUsers(t, ":"+argon2PHC("argon2id", "pass", 64, 1, 1))); err == nil {
+		t.Error("NewUsers with empty username: no error")
+	}
+}
@@ -0,0 +1,177 @@
+package geo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// DomainType is the type of a geosite domain.
+type DomainType int
+
+// Domain types in geosite.dat.
+const (
+	DomainKeyword DomainType = iota // matches domains contain the value
+	DomainRegex                     // matches domains with the regular expression
+	DomainSuffix                    // matches the domain and its subdomains
+	DomainFull                      // matches the domain only
+)
+
+// SiteDomain is a domain in the geosite list.
+type SiteDomain struct {
+	Type  DomainType
+	Value string
+	Attrs []string
+}
+
+// LoadSites loads the domain lists of codes from the v2ray geosite.dat file at path,
+// a code can be NAME or NAME@ATTR to select the domains with attribute ATTR only.
+// the result is keyed by the lower case codes.
+func LoadSites(path string, codes []string) (map[string][]SiteDomain, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// name -> codes with the name
+	names := make(map[string][]string)
+	for _, code := range codes {
+		code = strings.ToLower(code)
+		name, _, _ := strings.Cut(code, "@")
+		names[name] = append(names[name], code)
+	}
+
+	sites := make(map[string][]SiteDomain)
+
+	// message GeoSiteList { repeated GeoSite entry = 1; }
+	err = readFields(buf, func(num int, _ uint64, entry []byte) error {
+		if num != 1 || entry == nil {
+			return nil
+		}
+
+		// message GeoSite { string country_code = 1; repeated Domain domain = 2; }
+		var name string
+		var domains [][]byte
+		err := readFields(entry, func(num int, _ uint64, b []byte) error {
+			switch num {
+			case 1:
+				name = strings.ToLower(string(b))
+			case 2:
+				domains = append(domains, b)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		matched, ok := names[name]
+		if !ok {
+			return nil
+		}
+
+		for _, b := range domains {
+			d, err := parseSiteDomain(b)
+			if err != nil {
+				return fmt.Errorf("geosite %s: %w", name, err)
+			}
+
+			for _, code := range matched {
+				if _, attr, ok := strings.Cut(code, "@"); ok && !slices.Contains(d.Attrs, attr) {
+					continue
+				}
+				sites[code] = append(sites[code], d)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, code := range codes {
+		if _, ok := sites[strings.ToLower(code)]; !ok {
+			return nil, fmt.Errorf("%s: geosite %s not found", path, code)
+		}
+	}
+
+	return sites, nil
+}
+
+// parseSiteDomain parses the domain message:
+// message Domain { Type type = 1; string value = 2; repeated Attribute attribute = 3; }
+// message Attribute { string key = 1; oneof typed_value { bool bool_value = 2; int64 int_value = 3; } }
+// the values are lower cased except the regular expressions, as `\D` and `\d` differ.
+func parseSiteDomain(b []byte) (d SiteDomain, err error) {
+	err = readFields(b, func(num int, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			d.Type = DomainType(v)
+		case 2:
+			d.Value = string(b)
+		case 3:
+			return readFields(b, func(num int, _ uint64, b []byte) error {
+				if num == 1 {
+					d.Attrs = append(d.Attrs, strings.ToLower(string(b)))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if d.Type != DomainRegex {
+		d.Value = strings.ToLower(d.Value)
+	}
+	return
+}
+
+var errInvalidProtobuf = errors.New("invalid protobuf data")
+
+// readFields reads the fields of a protobuf message and calls f with each field,
+// v is the value of varint fields and b is the data of length-delimited fields.
+func readFields(buf []byte, f func(num int, v uint64, b []byte) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errInvalidProtobuf
+		}
+		buf = buf[n:]
+
+		var v uint64
+		var b []byte
+		switch key & 0x7 {
+		case 0: // varint
+			if v, n = binary.Uvarint(buf); n <= 0 {
+				return errInvalidProtobuf
+			}
+			buf = buf[n:]
+		case 1: // fixed64
+			if len(buf) < 8 {
+				return errInvalidProtobuf
+			}
+			buf = buf[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < l {
+				return errInvalidProtobuf
+			}
+			b, buf = buf[n:n+int(l)], buf[n+int(l):]
+		case 5: // fixed32
+			if len(buf) < 4 {
+				return errInvalidProtobuf
+			}
+			buf = buf[4:]
+		default:
+			return errInvalidProtobuf
+		}
+
+		if err := f(int(key>>3), v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
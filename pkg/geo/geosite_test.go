@@ -0,0 +1,79 @@
+package geo
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// pbBytes encodes a length-delimited protobuf field.
+func pbBytes(num int, b []byte) []byte {
+	buf := binary.AppendUvarint(nil, uint64(num<<3|2))
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// pbVarint encodes a varint protobuf field.
+func pbVarint(num int, v uint64) []byte {
+	buf := binary.AppendUvarint(nil, uint64(num<<3))
+	return binary.AppendUvarint(buf, v)
+}
+
+// pbDomain encodes a geosite domain, the value is put before the type like some generators do.
+func pbDomain(typ DomainType, value string, attrs ...string) []byte {
+	b := pbBytes(2, []byte(value))
+	b = append(b, pbVarint(1, uint64(typ))...)
+	for _, attr := range attrs {
+		b = append(b, pbBytes(3, append(pbBytes(1, []byte(attr)), pbVarint(2, 1)...))...)
+	}
+	return b
+}
+
+func TestLoadSites(t *testing.T) {
+	site := pbBytes(1, []byte("TEST"))
+	site = append(site, pbBytes(2, pbDomain(DomainSuffix, "Example.COM"))...)
+	site = append(site, pbBytes(2, pbDomain(DomainRegex, `^\D+\.Example\.com$`, "CN"))...)
+	site = append(site, pbBytes(2, pbDomain(DomainFull, "WWW.Example.net", "Ads", "cn"))...)
+	site = append(site, pbBytes(2, pbDomain(DomainKeyword, "Keyword"))...)
+
+	other := append(pbBytes(1, []byte("other")), pbBytes(2, pbDomain(DomainFull, "other.com"))...)
+
+	path := filepath.Join(t.TempDir(), "geosite.dat")
+	if err := os.WriteFile(path, append(pbBytes(1, site), pbBytes(1, other)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sites, err := LoadSites(path, []string{"test", "Test@CN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]SiteDomain{
+		"test": {
+			{Type: DomainSuffix, Value: "example.com"},
+			{Type: DomainRegex, Value: `^\D+\.Example\.com$`, Attrs: []string{"cn"}},
+			{Type: DomainFull, Value: "www.example.net", Attrs: []string{"ads", "cn"}},
+			{Type: DomainKeyword, Value: "keyword"},
+		},
+		"test@cn": {
+			{Type: DomainRegex, Value: `^\D+\.Example\.com$`, Attrs: []string{"cn"}},
+			{Type: DomainFull, Value: "www.example.net", Attrs: []string{"ads", "cn"}},
+		},
+	}
+	if !reflect.DeepEqual(sites, want) {
+		t.Errorf("LoadSites() = %+v, want %+v", sites, want)
+	}
+
+	if _, err := LoadSites(path, []string{"missing"}); err == nil {
+		t.Error("LoadSites of a missing code: no error")
+	}
+
+	if err := os.WriteFile(path, pbBytes(1, site)[:20], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSites(path, []string{"test"}); err == nil {
+		t.Error("LoadSites of a truncated file: no error")
+	}
+}
@@ -0,0 +1,296 @@
+// Package geo implements readers of geoip and geosite databases.
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// metadataStart is the marker before the metadata section of a MaxMind DB file.
+var metadataStart = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// IPDB is a geoip database in MaxMind DB format(.mmdb).
+// https://maxmind.github.io/MaxMind-DB/
+type IPDB struct {
+	tree       []byte
+	data       decoder
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+
+	countries sync.Map // data offset -> country code
+}
+
+// OpenIPDB opens the MaxMind DB file at path.
+func OpenIPDB(path string) (*IPDB, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := bytes.LastIndex(buf, metadataStart)
+	if i == -1 {
+		return nil, fmt.Errorf("%s: invalid mmdb file, metadata not found", path)
+	}
+
+	meta, _, err := decoder(buf[i+len(metadataStart):]).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid mmdb metadata: %w", path, err)
+	}
+
+	m, ok := meta.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid mmdb metadata", path)
+	}
+
+	db := &IPDB{
+		nodeCount:  toUint(m["node_count"]),
+		recordSize: toUint(m["record_size"]),
+		ipVersion:  toUint(m["ip_version"]),
+	}
+
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("%s: unsupported mmdb record size: %d", path, db.recordSize)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("%s: invalid mmdb search tree size", path)
+	}
+	db.tree, db.data = buf[:treeSize], decoder(buf[treeSize+16:i])
+
+	// the ipv4 addresses are stored in ::/96 of an ipv6 tree.
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+
+	return db, nil
+}
+
+// record returns the left(bit=0) or right(bit=1) record of node.
+func (db *IPDB) record(node uint, bit int) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6+uint(bit)*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(db.tree[node*8+uint(bit)*4:]))
+	}
+}
+
+// Country returns the upper case iso code of the country ip belongs to, empty if not found.
+func (db *IPDB) Country(ip netip.Addr) string {
+	ip = ip.Unmap()
+
+	node, bits := uint(0), 128
+	if ip.Is4() {
+		node, bits = db.ipv4Start, 32
+	} else if db.ipVersion == 4 {
+		return ""
+	}
+
+	b := ip.As16()
+	if ip.Is4() {
+		copy(b[:], b[12:])
+	}
+
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		node = db.record(node, int(b[i/8]>>(7-i%8))&1)
+	}
+
+	if node <= db.nodeCount {
+		return ""
+	}
+
+	offset := node - db.nodeCount - 16
+	if code, ok := db.countries.Load(offset); ok {
+		return code.(string)
+	}
+
+	v, _, err := db.data.decode(offset)
+	if err != nil {
+		return ""
+	}
+
+	var code string
+	if m, ok := v.(map[string]any); ok {
+		for _, key := range []string{"country", "registered_country"} {
+			if c, ok := m[key].(map[string]any); ok {
+				if code, _ = c["iso_code"].(string); code != "" {
+					break
+				}
+			}
+		}
+	}
+
+	code = strings.ToUpper(code)
+	db.countries.Store(offset, code)
+
+	return code
+}
+
+// decoder decodes the data section of a MaxMind DB file.
+type decoder []byte
+
+var errInvalidData = errors.New("invalid data")
+
+// decode decodes the value at offset, returns the value and the offset after it.
+func (d decoder) decode(offset uint) (any, uint, error) {
+	if offset >= uint(len(d)) {
+		return nil, 0, errInvalidData
+	}
+
+	ctrl := d[offset]
+	offset++
+
+	typ := uint(ctrl >> 5)
+	if typ == 1 { // pointer
+		ptr, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(ptr)
+		return v, next, err
+	}
+
+	if typ == 0 { // extended type
+		if offset >= uint(len(d)) {
+			return nil, 0, errInvalidData
+		}
+		typ = 7 + uint(d[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d)) {
+			return nil, 0, errInvalidData
+		}
+		v := uint(0)
+		for _, c := range d[offset : offset+n] {
+			v = v<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[n-1] + v
+		offset += n
+	}
+
+	switch typ {
+	case 7: // map
+		m := make(map[string]any, size)
+		for range size {
+			k, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errInvalidData
+			}
+			v, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key], offset = v, next
+		}
+		return m, offset, nil
+
+	case 11: // array
+		a := make([]any, 0, size)
+		for range size {
+			v, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a, offset = append(a, v), next
+		}
+		return a, offset, nil
+
+	case 14: // boolean
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d)) {
+		return nil, 0, errInvalidData
+	}
+	b, next := d[offset:offset+size], offset+size
+
+	switch typ {
+	case 2: // utf-8 string
+		return string(b), next, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errInvalidData
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case 4, 10: // bytes, uint128
+		return []byte(b), next, nil
+	case 5, 6, 9: // uint16, uint32, uint64
+		if size > 8 {
+			return nil, 0, errInvalidData
+		}
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	case 8: // int32
+		if size > 4 {
+			return nil, 0, errInvalidData
+		}
+		v := uint32(0)
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		shift := 32 - 8*size
+		return int32(v<<shift) >> shift, next, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errInvalidData
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported data type: %d", typ)
+}
+
+// pointer returns the offset a pointer points to and the offset after the pointer.
+func (d decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3&0x3) + 1
+	if offset+n > uint(len(d)) {
+		return 0, 0, errInvalidData
+	}
+
+	v := uint(0)
+	if n < 4 {
+		v = uint(ctrl & 0x7)
+	}
+	for _, c := range d[offset : offset+n] {
+		v = v<<8 | uint(c)
+	}
+
+	return v + []uint{0, 2048, 526336, 0}[n-1], offset + n, nil
+}
+
+func toUint(v any) uint {
+	if n, ok := v.(uint64); ok {
+		return uint(n)
+	}
+	return 0
+}
@@ -0,0 +1,241 @@
+package geo
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// mmdbCtrl returns the control bytes of type typ with size.
+func mmdbCtrl(typ, size uint) []byte {
+	var b []byte
+	var ext []byte
+	if typ > 7 {
+		b, ext = []byte{0}, []byte{byte(typ - 7)}
+	} else {
+		b = []byte{byte(typ << 5)}
+	}
+
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+		b[0] |= byte(size)
+	case size < 285:
+		b[0] |= 29
+		sizeBytes = []byte{byte(size - 29)}
+	case size < 65821:
+		b[0] |= 30
+		sizeBytes = binary.BigEndian.AppendUint16(nil, uint16(size-285))
+	default:
+		b[0] |= 31
+		v := size - 65821
+		sizeBytes = []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+
+	return slices.Concat(b, ext, sizeBytes)
+}
+
+// mmdbUint returns the minimal big endian bytes of v.
+func mmdbUint(v uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// mmdbPointer encodes a pointer to ptr in n bytes.
+func mmdbPointer(ptr uint, n int) []byte {
+	ptr -= []uint{0, 2048, 526336, 0}[n-1]
+	ctrl := byte(1<<5 | (n-1)<<3)
+	if n < 4 {
+		ctrl |= byte(ptr>>(8*n)) & 0x7
+	}
+	b := []byte{ctrl}
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(ptr>>(8*i)))
+	}
+	return b
+}
+
+type mmdbUint16 uint16
+type mmdbUint32 uint32
+type mmdbRaw []byte // encoded data, e.g. a pointer
+
+// mmdbEncode encodes v in MaxMind DB data format.
+func mmdbEncode(v any) []byte {
+	switch v := v.(type) {
+	case mmdbRaw:
+		return v
+	case string:
+		return append(mmdbCtrl(2, uint(len(v))), v...)
+	case float64:
+		return append(mmdbCtrl(3, 8), binary.BigEndian.AppendUint64(nil, math.Float64bits(v))...)
+	case []byte:
+		return append(mmdbCtrl(4, uint(len(v))), v...)
+	case mmdbUint16:
+		b := mmdbUint(uint64(v))
+		return append(mmdbCtrl(5, uint(len(b))), b...)
+	case mmdbUint32:
+		b := mmdbUint(uint64(v))
+		return append(mmdbCtrl(6, uint(len(b))), b...)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		b := mmdbCtrl(7, uint(len(v)))
+		for _, k := range keys {
+			b = append(b, mmdbEncode(k)...)
+			b = append(b, mmdbEncode(v[k])...)
+		}
+		return b
+	case int32:
+		b := binary.BigEndian.AppendUint32(nil, uint32(v))
+		if v >= 0 {
+			b = mmdbUint(uint64(v))
+		}
+		return append(mmdbCtrl(8, uint(len(b))), b...)
+	case uint64:
+		b := mmdbUint(v)
+		return append(mmdbCtrl(9, uint(len(b))), b...)
+	case []any:
+		b := mmdbCtrl(11, uint(len(v)))
+		for _, e := range v {
+			b = append(b, mmdbEncode(e)...)
+		}
+		return b
+	case bool:
+		if v {
+			return mmdbCtrl(14, 1)
+		}
+		return mmdbCtrl(14, 0)
+	case float32:
+		return append(mmdbCtrl(15, 4), binary.BigEndian.AppendUint32(nil, math.Float32bits(v))...)
+	}
+	panic("unsupported type")
+}
+
+func TestDecoderRoundTrip(t *testing.T) {
+	long, longer := strings.Repeat("x", 300), strings.Repeat("y", 70000)
+
+	in := map[string]any{
+		"string":  "hello",
+		"empty":   "",
+		"long":    long,
+		"longer":  longer,
+		"double":  1.5,
+		"float":   float32(-2.5),
+		"bytes":   []byte{1, 2, 3},
+		"uint16":  mmdbUint16(500),
+		"uint32":  mmdbUint32(1 << 30),
+		"uint64":  uint64(1 << 60),
+		"zero":    uint64(0),
+		"int32":   int32(-5),
+		"int32+":  int32(70000),
+		"true":    true,
+		"false":   false,
+		"array":   []any{uint64(1), "a", []any{}},
+		"country": map[string]any{"iso_code": "CN", "geoname_id": mmdbUint32(1814991)},
+	}
+
+	want := map[string]any{
+		"string":  "hello",
+		"empty":   "",
+		"long":    long,
+		"longer":  longer,
+		"double":  1.5,
+		"float":   float32(-2.5),
+		"bytes":   []byte{1, 2, 3},
+		"uint16":  uint64(500),
+		"uint32":  uint64(1 << 30),
+		"uint64":  uint64(1 << 60),
+		"zero":    uint64(0),
+		"int32":   int32(-5),
+		"int32+":  int32(70000),
+		"true":    true,
+		"false":   false,
+		"array":   []any{uint64(1), "a", []any{}},
+		"country": map[string]any{"iso_code": "CN", "geoname_id": uint64(1814991)},
+	}
+
+	b := mmdbEncode(in)
+	v, next, err := decoder(b).decode(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != uint(len(b)) {
+		t.Errorf("next offset = %d, want %d", next, len(b))
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("decode() = %#v, want %#v", v, want)
+	}
+}
+
+func TestDecoderPointer(t *testing.T) {
+	for _, tt := range []struct {
+		ptr uint
+		n   int
+	}{
+		{0, 1}, {2047, 1},
+		{2048, 2}, {526335, 2},
+		{526336, 3}, {134744063, 3},
+		{0, 4}, {1<<32 - 1, 4},
+	} {
+		b := mmdbPointer(tt.ptr, tt.n)
+		if len(b) != tt.n+1 {
+			t.Fatalf("pointer %d encoded in %d bytes, want %d", tt.ptr, len(b)-1, tt.n)
+		}
+
+		ptr, next, err := decoder(b).pointer(b[0], 1)
+		if err != nil || ptr != tt.ptr || next != uint(len(b)) {
+			t.Errorf("pointer(%x) = %d, %d, %v, want %d, %d", b, ptr, next, err, tt.ptr, len(b))
+		}
+
+		if _, _, err := decoder(b[:len(b)-1]).pointer(b[0], 1); !errors.Is(err, errInvalidData) {
+			t.Errorf("pointer of truncated %x: err = %v, want %v", b, err, errInvalidData)
+		}
+	}
+
+	// values reached by pointers of all sizes, the offset after a pointer is the one after itself.
+	d := make([]byte, 526336+16)
+	copy(d, mmdbEncode("near"))
+	copy(d[3000:], mmdbEncode("middle"))
+	copy(d[526336:], mmdbEncode("far"))
+	start := uint(len(d))
+	d = append(d, mmdbEncode(map[string]any{
+		"a": mmdbRaw(mmdbPointer(0, 1)),
+		"b": mmdbRaw(mmdbPointer(3000, 2)),
+		"c": mmdbRaw(mmdbPointer(526336, 3)),
+		"d": mmdbRaw(mmdbPointer(3000, 4)),
+	})...)
+
+	v, next, err := decoder(d).decode(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"a": "near", "b": "middle", "c": "far", "d": "middle"}
+	if !reflect.DeepEqual(v, want) || next != uint(len(d)) {
+		t.Errorf("decode() = %v, %d, want %v, %d", v, next, want, len(d))
+	}
+}
+
+func TestDecoderInvalid(t *testing.T) {
+	b := mmdbEncode(map[string]any{"key": "value", "n": uint64(1 << 40)})
+	for i := range len(b) {
+		if _, _, err := decoder(b[:i]).decode(0); err == nil {
+			t.Errorf("decode of %d bytes truncated data: no error", i)
+		}
+	}
+
+	// map keys must be strings
+	b = slices.Concat(mmdbCtrl(7, 1), mmdbEncode(uint64(1)), mmdbEncode("v"))
+	if _, _, err := decoder(b).decode(0); !errors.Is(err, errInvalidData) {
+		t.Errorf("decode of map with uint key: err = %v, want %v", err, errInvalidData)
+	}
+}
@@ -1,41 +1,145 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	stdlog "log"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
-var enable = false
+// Level is the importance of a log event.
+type Level = slog.Level
 
-// Set sets the logger's verbose mode and output flags.
-func Set(verbose bool, flag int) {
-	enable = verbose
+// Log levels.
+const (
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+)
+
+var (
+	level   = LevelInfo
+	handler slog.Handler // json handler, nil means text output
+)
+
+// ParseLevel parses the level name: debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	var l Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return l, fmt.Errorf("invalid log level: %s", s)
+	}
+	return l, nil
+}
+
+// Set sets the logger's level, output format and flags,
+// format should be "text" or "json".
+func Set(l Level, format string, flag int) {
+	level = l
 	stdlog.SetFlags(flag)
+
+	if format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+			AddSource:   flag&(stdlog.Lshortfile|stdlog.Llongfile) != 0,
+			Level:       l,
+			ReplaceAttr: replaceAttr,
+		})
+	}
 }
 
+// Enabled reports whether events at level l will be logged.
+func Enabled(l Level) bool { return l >= level }
+
+// Debug logs an event at debug level, args are key-value pairs of fields.
+func Debug(msg string, args ...any) { output(LevelDebug, msg, args) }
+
+// Info logs an event at info level, args are key-value pairs of fields.
+func Info(msg string, args ...any) { output(LevelInfo, msg, args) }
+
+// Warn logs an event at warn level, args are key-value pairs of fields.
+func Warn(msg string, args ...any) { output(LevelWarn, msg, args) }
+
+// Error logs an event at error level, args are key-value pairs of fields.
+func Error(msg string, args ...any) { output(LevelError, msg, args) }
+
 // F prints debug log.
 func F(f string, v ...any) {
-	if enable {
-		stdlog.Output(2, fmt.Sprintf(f, v...))
+	if Enabled(LevelDebug) {
+		output(LevelDebug, fmt.Sprintf(f, v...), nil)
 	}
 }
 
-// Print prints log.
-func Print(v ...any) {
-	stdlog.Print(v...)
-}
+// Print prints info log.
+func Print(v ...any) { output(LevelInfo, fmt.Sprint(v...), nil) }
 
-// Printf prints log.
-func Printf(f string, v ...any) {
-	stdlog.Printf(f, v...)
-}
+// Printf prints info log.
+func Printf(f string, v ...any) { output(LevelInfo, fmt.Sprintf(f, v...), nil) }
 
-// Fatal log and exit.
+// Fatal logs at error level and exits.
 func Fatal(v ...any) {
-	stdlog.Fatal(v...)
+	output(LevelError, fmt.Sprint(v...), nil)
+	os.Exit(1)
 }
 
-// Fatalf log and exit.
+// Fatalf logs at error level and exits.
 func Fatalf(f string, v ...any) {
-	stdlog.Fatalf(f, v...)
+	output(LevelError, fmt.Sprintf(f, v...), nil)
+	os.Exit(1)
+}
+
+// output logs the event, it must be called directly by the exported functions
+// to get the right caller.
+func output(l Level, msg string, args []any) {
+	if !Enabled(l) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	r := slog.NewRecord(time.Now(), l, msg, pcs[0])
+	r.Add(args...)
+
+	if handler != nil {
+		handler.Handle(context.Background(), r)
+		return
+	}
+
+	var sb strings.Builder
+	if l != LevelDebug && l != LevelInfo {
+		sb.WriteString(l.String())
+		sb.WriteByte(' ')
+	}
+	sb.WriteString(msg)
+	r.Attrs(func(a slog.Attr) bool {
+		sb.WriteByte(' ')
+		sb.WriteString(a.Key)
+		sb.WriteByte('=')
+		sb.WriteString(textValue(a.Value))
+		return true
+	})
+
+	stdlog.Output(3, sb.String())
+}
+
+// textValue formats v in text output, quoted if needed.
+func textValue(v slog.Value) string {
+	s := v.String()
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// replaceAttr formats durations as seconds in json output.
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.Float64(a.Key, a.Value.Duration().Seconds())
+	}
+	return a
 }
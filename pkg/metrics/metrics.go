@@ -0,0 +1,293 @@
+// Package metrics implements a tiny metrics collector which can be exported
+// in the prometheus text format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is a named metric family.
+type metric interface {
+	name() string
+	writeTo(w io.Writer)
+}
+
+var (
+	mu      sync.Mutex
+	metrics []metric
+)
+
+func register(m metric) {
+	mu.Lock()
+	defer mu.Unlock()
+	metrics = append(metrics, m)
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+}
+
+// WriteTo writes all the registered metrics to w in the prometheus text format.
+func WriteTo(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, m := range metrics {
+		m.writeTo(w)
+	}
+}
+
+// Handler returns a http handler which serves the registered metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+// vec is a metric family partitioned by label values.
+type vec[T any] struct {
+	fqName string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.RWMutex
+	keys   []string
+	values map[string]*T
+	newT   func() *T
+}
+
+func newVec[T any](name, help, typ string, labels []string, newT func() *T) *vec[T] {
+	return &vec[T]{fqName: name, help: help, typ: typ, labels: labels,
+		values: make(map[string]*T), newT: newT}
+}
+
+func (v *vec[T]) name() string { return v.fqName }
+
+// with returns the metric with the given label values, creates it if not exist.
+func (v *vec[T]) with(values ...string) *T {
+	key := v.labelPairs(values)
+
+	v.mu.RLock()
+	t, ok := v.values[key]
+	v.mu.RUnlock()
+	if ok {
+		return t
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t, ok = v.values[key]; !ok {
+		t = v.newT()
+		v.values[key] = t
+		v.keys = append(v.keys, key)
+		sort.Strings(v.keys)
+	}
+	return t
+}
+
+// delete deletes the metric with the given label values.
+func (v *vec[T]) delete(values ...string) {
+	key := v.labelPairs(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.values[key]; ok {
+		delete(v.values, key)
+		v.keys = slices.DeleteFunc(v.keys, func(k string) bool { return k == key })
+	}
+}
+
+func (v *vec[T]) each(fn func(labels string, t *T)) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, key := range v.keys {
+		fn(key, v.values[key])
+	}
+}
+
+func (v *vec[T]) writeHeader(w io.Writer) {
+	io.WriteString(w, "# HELP "+v.fqName+" "+v.help+"\n")
+	io.WriteString(w, "# TYPE "+v.fqName+" "+v.typ+"\n")
+}
+
+// labelPairs returns the label pairs string: `k1="v1",k2="v2"`.
+func (v *vec[T]) labelPairs(values []string) string {
+	var sb strings.Builder
+	for i, label := range v.labels {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		sb.WriteString(label)
+		sb.WriteString(`="`)
+		sb.WriteString(escape(value))
+		sb.WriteByte('"')
+	}
+	return sb.String()
+}
+
+// Counter is a metric which only goes up.
+type Counter struct{ bits uint64 }
+
+// Inc increases the counter by 1.
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v to the counter.
+func (c *Counter) Add(v float64) { addFloat(&c.bits, v) }
+
+// Value returns the value of counter.
+func (c *Counter) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&c.bits)) }
+
+// CounterVec is a counter family partitioned by label values.
+type CounterVec struct{ *vec[Counter] }
+
+// NewCounterVec creates and registers a new CounterVec.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	register(c)
+	return c
+}
+
+// With returns the counter with the given label values.
+func (c *CounterVec) With(values ...string) *Counter { return c.with(values...) }
+
+// Delete deletes the counter with the given label values.
+func (c *CounterVec) Delete(values ...string) { c.delete(values...) }
+
+func (c *CounterVec) writeTo(w io.Writer) {
+	c.writeHeader(w)
+	c.each(func(labels string, t *Counter) {
+		writeSample(w, c.fqName, labels, t.Value())
+	})
+}
+
+// Gauge is a metric which can go up and down.
+type Gauge struct{ bits uint64 }
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) { atomic.StoreUint64(&g.bits, math.Float64bits(v)) }
+
+// Inc increases the gauge by 1.
+func (g *Gauge) Inc() { addFloat(&g.bits, 1) }
+
+// Dec decreases the gauge by 1.
+func (g *Gauge) Dec() { addFloat(&g.bits, -1) }
+
+// Value returns the value of gauge.
+func (g *Gauge) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&g.bits)) }
+
+// GaugeVec is a gauge family partitioned by label values.
+type GaugeVec struct{ *vec[Gauge] }
+
+// NewGaugeVec creates and registers a new GaugeVec.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	register(g)
+	return g
+}
+
+// With returns the gauge with the given label values.
+func (g *GaugeVec) With(values ...string) *Gauge { return g.with(values...) }
+
+// Delete deletes the gauge with the given label values.
+func (g *GaugeVec) Delete(values ...string) { g.delete(values...) }
+
+func (g *GaugeVec) writeTo(w io.Writer) {
+	g.writeHeader(w)
+	g.each(func(labels string, t *Gauge) {
+		writeSample(w, g.fqName, labels, t.Value())
+	})
+}
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec is a histogram family partitioned by label values.
+type HistogramVec struct{ *vec[Histogram] }
+
+// NewHistogramVec creates and registers a new HistogramVec.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	register(h)
+	return h
+}
+
+// With returns the histogram with the given label values.
+func (h *HistogramVec) With(values ...string) *Histogram { return h.with(values...) }
+
+// Delete deletes the histogram with the given label values.
+func (h *HistogramVec) Delete(values ...string) { h.delete(values...) }
+
+func (h *HistogramVec) writeTo(w io.Writer) {
+	h.writeHeader(w)
+	h.each(func(labels string, t *Histogram) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		for i, b := range t.buckets {
+			le := `le="` + strconv.FormatFloat(b, 'g', -1, 64) + `"`
+			writeSample(w, h.fqName+"_bucket", labels+sep+le, float64(t.counts[i]))
+		}
+		writeSample(w, h.fqName+"_bucket", labels+sep+`le="+Inf"`, float64(t.count))
+		writeSample(w, h.fqName+"_sum", labels, t.sum)
+		writeSample(w, h.fqName+"_count", labels, float64(t.count))
+	})
+}
+
+func writeSample(w io.Writer, name, labels string, v float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	io.WriteString(w, name+" "+strconv.FormatFloat(v, 'g', -1, 64)+"\n")
+}
+
+func addFloat(bits *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		if atomic.CompareAndSwapUint64(bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+var replacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string) string { return replacer.Replace(s) }
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var accessLog atomic.Pointer[AccessLog]
+
+// SetAccessLog sets the access log which sessions report to when closed, nil to disable it.
+func SetAccessLog(l *AccessLog) { accessLog.Store(l) }
+
+// AccessLog writes one json record per line for each closed session.
+type AccessLog struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// accessRecord is the record of a session in access log.
+type accessRecord struct {
+	Start     time.Time `json:"start"`
+	Listener  string    `json:"listener"`
+	Network   string    `json:"network"`
+	Client    string    `json:"client"`
+	User      string    `json:"user,omitempty"`
+	Target    string    `json:"target"`
+	Rule      string    `json:"rule,omitempty"`
+	Forwarder string    `json:"forwarder,omitempty"`
+	BytesUp   int64     `json:"bytes_up"`
+	BytesDown int64     `json:"bytes_down"`
+	Duration  float64   `json:"duration"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// OpenAccessLog opens the access log file at path in append mode.
+func OpenAccessLog(path string) (*AccessLog, error) {
+	l := &AccessLog{path: path}
+	return l, l.Reopen()
+}
+
+// Reopen closes and opens the access log file again, used after log rotation.
+func (l *AccessLog) Reopen() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f = f
+
+	return nil
+}
+
+// Write writes the record of session s.
+func (l *AccessLog) Write(s *Session) error {
+	r := accessRecord{
+		Start:     s.Start,
+		Listener:  s.Server,
+		Network:   s.Network,
+		Client:    s.Src.String(),
+		User:      s.User,
+		Target:    s.Target,
+		Rule:      s.RuleGroup(),
+		Forwarder: s.Forwarder(),
+		BytesUp:   s.BytesUp(),
+		BytesDown: s.BytesDown(),
+		Duration:  time.Since(s.Start).Seconds(),
+	}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.f.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/nadoo/glider/pkg/log"
+	"github.com/nadoo/glider/pkg/metrics"
+)
+
+// ErrBlocked is returned by the dialers which block the connections by rules, e.g. block://.
+var ErrBlocked = errors.New("blocked by rule")
+
+var blocksTotal = metrics.NewCounterVec("glider_blocked_total",
+	"Total number of connections and dns queries blocked by rules.", "group", "network")
+
+// DefaultBlockPage is the body of the response to blocked http requests.
+const DefaultBlockPage = `<!DOCTYPE html>
+<html>
+<head><title>403 Forbidden</title></head>
+<body>
+<h1>403 Forbidden</h1>
+<p>The access to this site is blocked by the proxy rules.</p>
+</body>
+</html>
+`
+
+var blockPage atomic.Pointer[[]byte]
+
+// SetBlockPage sets the body of the response to blocked http requests, nil to use the default page.
+func SetBlockPage(b []byte) { blockPage.Store(&b) }
+
+// BlockPage returns the body of the response to blocked http requests.
+func BlockPage() []byte {
+	if b := blockPage.Load(); b != nil && *b != nil {
+		return *b
+	}
+	return []byte(DefaultBlockPage)
+}
+
+// LogBlocked counts and logs the connection or dns query to target blocked by the rule group,
+// network is "tcp", "udp" or "dns".
+func LogBlocked(group, network, client, target string) {
+	blocksTotal.With(group, network).Inc()
+	log.Info("[block] blocked by rule", "rule_group", group, "network", network, "client", client, "target", target)
+}
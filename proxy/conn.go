@@ -53,30 +53,37 @@ func (c *Conn) Close() error {
 
 // Relay relays between left and right.
 func Relay(left, right net.Conn) error {
-	var err, err1 error
+	_, _, err := relay(left, right)
+	return err
+}
+
+// relay relays between left and right,
+// returns the bytes copied from left to right and from right to left.
+func relay(left, right net.Conn) (up, down int64, err error) {
+	var err1 error
 	var wg sync.WaitGroup
 	var wait = 5 * time.Second
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		_, err1 = Copy(right, left)
+		up, err1 = Copy(right, left)
 		right.SetReadDeadline(time.Now().Add(wait)) // unblock read on right
 	}()
 
-	_, err = Copy(left, right)
+	down, err = Copy(left, right)
 	left.SetReadDeadline(time.Now().Add(wait)) // unblock read on left
 	wg.Wait()
 
 	if err1 != nil && !errors.Is(err1, os.ErrDeadlineExceeded) {
-		return err1
+		return up, down, err1
 	}
 
 	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
-		return err
+		return up, down, err
 	}
 
-	return nil
+	return up, down, nil
 }
 
 // Copy copies from src to dst.
@@ -178,7 +185,8 @@ func CopyBuffer(dst io.Writer, src io.Reader) (written int64, err error) {
 // CopyUDP copys from src to dst at target with read timeout.
 // if step sets to non-zero value,
 // the read timeout will be increased from 0 to timeout by step in every read operation.
-func CopyUDP(dst net.PacketConn, writeTo net.Addr, src net.PacketConn, timeout time.Duration, step time.Duration) error {
+// it returns the number of bytes written to dst.
+func CopyUDP(dst net.PacketConn, writeTo net.Addr, src net.PacketConn, timeout time.Duration, step time.Duration) (written int64, err error) {
 	buf := pool.GetBuffer(UDPBufSize)
 	defer pool.PutBuffer(buf)
 
@@ -191,16 +199,17 @@ func CopyUDP(dst net.PacketConn, writeTo net.Addr, src net.PacketConn, timeout t
 		src.SetReadDeadline(time.Now().Add(t))
 		n, addr, err := src.ReadFrom(buf)
 		if err != nil {
-			return err
+			return written, err
 		}
 
 		if writeTo != nil {
 			addr = writeTo
 		}
 
-		_, err = dst.WriteTo(buf[:n], addr)
+		n, err = dst.WriteTo(buf[:n], addr)
+		written += int64(n)
 		if err != nil {
-			return err
+			return written, err
 		}
 	}
 }
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/nadoo/glider/pkg/auth"
 	"github.com/nadoo/glider/pkg/log"
 	"github.com/nadoo/glider/proxy"
 )
@@ -22,7 +23,10 @@ type HTTP struct {
 	addr     string
 	user     string
 	password string
+	users    *auth.Users
 	pretend  bool
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -55,6 +59,13 @@ func NewHTTP(s string, d proxy.Dialer, p proxy.Proxy) (*HTTP, error) {
 		h.pretend = true
 	}
 
+	if path := u.Query().Get("users"); path != "" {
+		h.users, err = auth.NewUsers(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return h, nil
 }
 
@@ -116,6 +127,9 @@ func extractUserPass(auth string) (username, password string, ok bool) {
 func init() {
 	proxy.AddUsage("http", `
 Http scheme:
-  http://[user:pass@]host:port
+  http://[user:pass@]host:port[?users=PATH]
+
+  users: users file of server, one USER:HASHED_PASS per line, hashed by bcrypt or argon2
+         (e.g. htpasswd -nB USER), reloaded when changed.
 `)
 }
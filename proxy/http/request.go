@@ -30,6 +30,7 @@ type request struct {
 	uri    string
 	proto  string
 	auth   string
+	user   string // authenticated user
 	header textproto.MIMEHeader
 
 	target string // target host with port
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -22,7 +23,10 @@ func NewHTTPServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *HTTP) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[http] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[http] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -32,6 +36,9 @@ func (s *HTTP) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[http] failed to accept: %v", err)
 			continue
 		}
@@ -40,6 +47,12 @@ func (s *HTTP) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *HTTP) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *HTTP) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves a connection.
 func (s *HTTP) Serve(cc net.Conn) {
 	if c, ok := cc.(*net.TCPConn); ok {
@@ -66,12 +79,14 @@ func (s *HTTP) Serve(cc net.Conn) {
 
 func (s *HTTP) servRequest(req *request, c *proxy.Conn) {
 	// Auth
-	if s.user != "" && s.password != "" {
-		if user, pass, ok := extractUserPass(req.auth); !ok || user != s.user || pass != s.password {
+	if s.authRequired() {
+		user, pass, ok := extractUserPass(req.auth)
+		if !ok || !s.verify(user, pass) {
 			io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic\r\n\r\n")
-			log.F("[http] auth failed from %s, auth info: %s:%s", c.RemoteAddr(), user, pass)
+			log.F("[http] auth failed from %s, user: %s", c.RemoteAddr(), user)
 			return
 		}
+		req.user = user
 	}
 
 	if req.method == "CONNECT" {
@@ -82,21 +97,36 @@ func (s *HTTP) servRequest(req *request, c *proxy.Conn) {
 	s.servHTTP(req, c)
 }
 
+// authRequired reports whether the clients need to be authenticated.
+func (s *HTTP) authRequired() bool {
+	return s.users != nil || (s.user != "" && s.password != "")
+}
+
+// verify verifies the user credentials, the users file takes precedence.
+func (s *HTTP) verify(user, pass string) bool {
+	if s.users != nil {
+		return s.users.Verify(user, pass)
+	}
+	return user == s.user && pass == s.password
+}
+
 func (s *HTTP) servHTTPS(r *request, c net.Conn) {
-	rc, dialer, err := s.proxy.Dial("tcp", r.uri)
+	sess := proxy.NewSession("http", "tcp", c.RemoteAddr(), r.uri)
+	sess.User = r.user
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", r.uri)
 	if err != nil {
-		io.WriteString(c, r.proto+" 502 ERROR\r\n\r\n")
-		log.F("[http] %s <-> %s [c] via %s, error in dial: %v", c.RemoteAddr(), r.uri, dialer.Addr(), err)
+		writeDialError(c, r.proto, err)
+		sess.Dialer, sess.Err = dialer, err
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
 	io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
 
-	log.F("[http] %s <-> %s [c] via %s", c.RemoteAddr(), r.uri, dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[http] %s <-> %s via %s, relay error: %v", c.RemoteAddr(), r.uri, dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -105,28 +135,37 @@ func (s *HTTP) servHTTPS(r *request, c net.Conn) {
 }
 
 func (s *HTTP) servHTTP(req *request, c *proxy.Conn) {
-	rc, dialer, err := s.proxy.Dial("tcp", req.target)
+	sess := proxy.NewSession("http", "tcp", c.RemoteAddr(), req.target)
+	sess.User = req.user
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", req.target)
 	if err != nil {
-		fmt.Fprintf(c, "%s 502 ERROR\r\n\r\n", req.proto)
-		log.F("[http] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), req.target, dialer.Addr(), err)
+		writeDialError(c, req.proto, err)
+		sess.Dialer, sess.Err = dialer, err
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
+	sess.Track(c, rc)
 
 	buf := pool.GetBytesBuffer()
 	defer pool.PutBytesBuffer(buf)
 
 	// send request to remote server
 	req.WriteBuf(buf)
-	_, err = rc.Write(buf.Bytes())
+	n, err := rc.Write(buf.Bytes())
+	sess.AddUp(int64(n))
 	if err != nil {
+		sess.Err = err
 		return
 	}
 
 	// copy the left request bytes to remote server. eg. length specificed or chunked body.
 	go func() {
 		if _, err := c.Reader().Peek(1); err == nil {
-			proxy.Copy(rc, c)
+			n, _ := proxy.Copy(rc, c)
+			sess.AddUp(n)
 			rc.SetDeadline(time.Now())
 			c.SetDeadline(time.Now())
 		}
@@ -138,6 +177,7 @@ func (s *HTTP) servHTTP(req *request, c *proxy.Conn) {
 	tpr := textproto.NewReader(r)
 	line, err := tpr.ReadLine()
 	if err != nil {
+		sess.Err = err
 		return
 	}
 
@@ -148,7 +188,7 @@ func (s *HTTP) servHTTP(req *request, c *proxy.Conn) {
 
 	header, err := tpr.ReadMIMEHeader()
 	if err != nil {
-		log.F("[http] read header error:%s", err)
+		sess.Err = err
 		return
 	}
 
@@ -159,8 +199,21 @@ func (s *HTTP) servHTTP(req *request, c *proxy.Conn) {
 	writeStartLine(buf, proto, code, status)
 	writeHeaders(buf, header)
 
-	log.F("[http] %s <-> %s via %s", c.RemoteAddr(), req.target, dialer.Addr())
-	c.Write(buf.Bytes())
+	n, _ = c.Write(buf.Bytes())
+	sess.AddDown(int64(n))
+
+	written, _ := proxy.Copy(c, r)
+	sess.AddDown(written)
+}
+
+// writeDialError writes the response of dial error err to client, blocked requests get the block page.
+func writeDialError(w io.Writer, proto string, err error) {
+	if !errors.Is(err, proxy.ErrBlocked) {
+		fmt.Fprintf(w, "%s 502 ERROR\r\n\r\n", proto)
+		return
+	}
 
-	proxy.Copy(c, r)
+	page := proxy.BlockPage()
+	fmt.Fprintf(w, "%s 403 Forbidden\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", proto, len(page))
+	w.Write(page)
 }
@@ -31,6 +31,8 @@ type KCP struct {
 	parityShards int
 
 	server proxy.Server
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -163,7 +165,10 @@ func NewKCPServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *KCP) ListenAndServe() {
 	l, err := kcp.ListenWithOptions(s.addr, s.block, s.dataShards, s.parityShards)
 	if err != nil {
-		log.Fatalf("[kcp] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[kcp] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -173,6 +178,9 @@ func (s *KCP) ListenAndServe() {
 	for {
 		c, err := l.AcceptKCP()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[kcp] failed to accept: %v", err)
 			continue
 		}
@@ -183,6 +191,12 @@ func (s *KCP) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *KCP) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *KCP) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves connections.
 func (s *KCP) Serve(c net.Conn) {
 	if s.server != nil {
@@ -192,19 +206,21 @@ func (s *KCP) Serve(c net.Conn) {
 
 	defer c.Close()
 
-	rc, dialer, err := s.proxy.Dial("tcp", "")
+	sess := proxy.NewSession("kcp", "tcp", c.RemoteAddr(), "")
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", "")
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[kcp] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), s.addr, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		s.proxy.Record(dialer, false)
 		return
 	}
 
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[kcp] %s <-> %s", c.RemoteAddr(), dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[kcp] %s <-> %s, relay error: %v", c.RemoteAddr(), dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -1,6 +1,8 @@
 package mixed
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"net/url"
 
@@ -17,6 +19,8 @@ type Mixed struct {
 
 	httpServer   *http.HTTP
 	socks5Server *socks5.Socks5
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -60,7 +64,10 @@ func (m *Mixed) ListenAndServe() {
 
 	l, err := net.Listen("tcp", m.addr)
 	if err != nil {
-		log.Fatalf("[mixed] failed to listen on %s: %v", m.addr, err)
+		m.listeners.Fail(fmt.Errorf("[mixed] failed to listen on %s: %w", m.addr, err))
+		return
+	}
+	if err := m.listeners.Add(l); err != nil {
 		return
 	}
 
@@ -69,6 +76,9 @@ func (m *Mixed) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if m.listeners.Closed() {
+				return
+			}
 			log.F("[mixed] failed to accept: %v", err)
 			continue
 		}
@@ -77,6 +87,14 @@ func (m *Mixed) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (m *Mixed) Close() error {
+	return errors.Join(m.listeners.Close(), m.socks5Server.Close())
+}
+
+// Listeners returns the tcp listeners of the server.
+func (m *Mixed) Listeners() *proxy.Listeners { return &m.listeners }
+
 // Serve serves connections.
 func (m *Mixed) Serve(c net.Conn) {
 	conn := proxy.NewConn(c)
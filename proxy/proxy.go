@@ -8,18 +8,65 @@ import (
 // Proxy is a dialer manager.
 type Proxy interface {
 	// Dial connects to the given address via the proxy.
-	Dial(network, addr string) (c net.Conn, dialer Dialer, err error)
+	Dial(meta *Metadata, network, addr string) (c net.Conn, dialer Dialer, err error)
 
 	// DialUDP connects to the given address via the proxy.
-	DialUDP(network, addr string) (pc net.PacketConn, dialer UDPDialer, err error)
+	DialUDP(meta *Metadata, network, addr string) (pc net.PacketConn, dialer UDPDialer, err error)
 
-	// Get the dialer by dstAddr.
-	NextDialer(dstAddr string) Dialer
+	// Get the dialer by dstAddr, network can be empty if it's unknown.
+	NextDialer(meta *Metadata, network, dstAddr string) Dialer
 
 	// Record records result while using the dialer from proxy.
 	Record(dialer Dialer, success bool)
 }
 
+// Metadata is the source context of a connection, it's used to choose the dialer
+// in proxy and can be nil if the connection is not from a client, e.g. dns queries.
+type Metadata struct {
+	// Listener is the listen url of the server, it's filled by the proxy
+	// returned by WithListener.
+	Listener string
+	// Src is the address of the client.
+	Src net.Addr
+	// User is the authenticated user name of the client, if any.
+	User string
+}
+
+// listenerProxy is a proxy which fills the listener in metadata.
+type listenerProxy struct {
+	Proxy
+	listener string
+}
+
+// WithListener returns a proxy which fills the listener in metadata
+// before calling p, it's used by the servers of listener.
+func WithListener(p Proxy, listener string) Proxy {
+	return &listenerProxy{Proxy: p, listener: listener}
+}
+
+func (p *listenerProxy) fill(meta *Metadata) *Metadata {
+	if meta == nil {
+		meta = &Metadata{}
+	}
+	meta.Listener = p.listener
+	return meta
+}
+
+// Dial connects to the given address via the proxy.
+func (p *listenerProxy) Dial(meta *Metadata, network, addr string) (net.Conn, Dialer, error) {
+	return p.Proxy.Dial(p.fill(meta), network, addr)
+}
+
+// DialUDP connects to the given address via the proxy.
+func (p *listenerProxy) DialUDP(meta *Metadata, network, addr string) (net.PacketConn, UDPDialer, error) {
+	return p.Proxy.DialUDP(p.fill(meta), network, addr)
+}
+
+// NextDialer returns the dialer by dstAddr.
+func (p *listenerProxy) NextDialer(meta *Metadata, network, dstAddr string) Dialer {
+	return p.Proxy.NextDialer(p.fill(meta), network, dstAddr)
+}
+
 var (
 	msg    strings.Builder
 	usages = make(map[string]string)
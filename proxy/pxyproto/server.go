@@ -20,6 +20,8 @@ type PxyProtoServer struct {
 	addr   string
 	proxy  proxy.Proxy
 	server proxy.Server
+
+	listeners proxy.Listeners
 }
 
 // NewPxyProtoServer returns a PxyProtoServer struct.
@@ -48,7 +50,10 @@ func NewPxyProtoServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *PxyProtoServer) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[pxyproto] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[pxyproto] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -58,6 +63,9 @@ func (s *PxyProtoServer) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[pxyproto] failed to accept: %v", err)
 			continue
 		}
@@ -66,6 +74,12 @@ func (s *PxyProtoServer) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *PxyProtoServer) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *PxyProtoServer) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves a connection.
 func (s *PxyProtoServer) Serve(cc net.Conn) {
 	c, err := newServerConn(cc)
@@ -1,6 +1,7 @@
 package redir
 
 import (
+	"fmt"
 	"net"
 	"net/netip"
 	"net/url"
@@ -17,6 +18,8 @@ type RedirProxy struct {
 	proxy proxy.Proxy
 	addr  string
 	ipv6  bool
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -56,7 +59,10 @@ func NewRedir6Server(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *RedirProxy) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[redir] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[redir] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 
@@ -65,6 +71,9 @@ func (s *RedirProxy) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[redir] failed to accept: %v", err)
 			continue
 		}
@@ -73,6 +82,12 @@ func (s *RedirProxy) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *RedirProxy) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *RedirProxy) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves connections.
 func (s *RedirProxy) Serve(cc net.Conn) {
 	defer cc.Close()
@@ -97,17 +112,18 @@ func (s *RedirProxy) Serve(cc net.Conn) {
 		return
 	}
 
-	rc, dialer, err := s.proxy.Dial("tcp", tgt)
+	sess := proxy.NewSession("redir", "tcp", c.RemoteAddr(), tgt)
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", tgt)
 	if err != nil {
-		log.F("[redir] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[redir] %s <-> %s via %s", c.RemoteAddr(), tgt, dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[redir] %s <-> %s via %s, relay error: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
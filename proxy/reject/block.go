@@ -0,0 +1,49 @@
+package reject
+
+import (
+	"net"
+
+	"github.com/nadoo/glider/proxy"
+)
+
+// A Block represents the base struct of a block proxy, unlike reject, the clients
+// are told that the requests are blocked and the dns queries are answered locally.
+type Block struct{}
+
+func init() {
+	proxy.RegisterDialer("block", NewBlockDialer)
+}
+
+// NewBlock returns a block proxy, block://.
+func NewBlock(s string, d proxy.Dialer) (*Block, error) {
+	return &Block{}, nil
+}
+
+// NewBlockDialer returns a block proxy dialer.
+func NewBlockDialer(s string, d proxy.Dialer) (proxy.Dialer, error) {
+	return NewBlock(s, d)
+}
+
+// Addr returns forwarder's address.
+func (s *Block) Addr() string { return "BLOCK" }
+
+// Dial connects to the address addr on the network net via the proxy.
+func (s *Block) Dial(network, addr string) (net.Conn, error) {
+	return nil, proxy.ErrBlocked
+}
+
+// DialUDP connects to the given address via the proxy.
+func (s *Block) DialUDP(network, addr string) (net.PacketConn, error) {
+	return nil, proxy.ErrBlocked
+}
+
+func init() {
+	proxy.AddUsage("block", `
+Block scheme:
+  block://
+
+Blocked http requests get a 403 page(see -blockpage), socks5 clients get the
+"connection not allowed by ruleset" reply, dns queries are answered with NXDOMAIN
+or 0.0.0.0(see -dnsblock) by the built-in dns server.
+`)
+}
@@ -2,9 +2,15 @@ package proxy
 
 import (
 	"errors"
+	"io"
 	"net"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/nadoo/glider/pkg/log"
 )
 
 // Server interface.
@@ -14,6 +20,111 @@ type Server interface {
 
 	// Serve serves a connection
 	Serve(c net.Conn)
+
+	// Close closes the listeners so ListenAndServe returns,
+	// the connections being served are not affected.
+	Close() error
+}
+
+// Listeners tracks the listeners and packet conns of a server,
+// servers use it to implement the Close method.
+type Listeners struct {
+	mu      sync.Mutex
+	closed  bool
+	err     error
+	closers []io.Closer
+}
+
+// Add adds c to the tracked listeners, if the listeners are already closed,
+// c will be closed immediately and net.ErrClosed returned.
+func (ls *Listeners) Add(c io.Closer) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.closed {
+		c.Close()
+		return net.ErrClosed
+	}
+
+	ls.closers = append(ls.closers, c)
+	return nil
+}
+
+// Closed reports whether the listeners are closed.
+func (ls *Listeners) Closed() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.closed
+}
+
+// Close closes all the tracked listeners.
+func (ls *Listeners) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var errs []error
+	for _, c := range ls.closers {
+		if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	ls.closed, ls.closers = true, nil
+
+	return errors.Join(errs...)
+}
+
+// Fail records the listen error err and closes all the tracked listeners,
+// servers call it instead of exiting so the caller can decide what to do.
+func (ls *Listeners) Fail(err error) {
+	log.Error(err.Error())
+
+	ls.mu.Lock()
+	if ls.err == nil {
+		ls.err = err
+	}
+	ls.mu.Unlock()
+
+	ls.Close()
+}
+
+// Err returns the listen error recorded by Fail.
+func (ls *Listeners) Err() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.err
+}
+
+// Listening reports whether the server has bound any listener.
+func (ls *Listeners) Listening() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return len(ls.closers) > 0
+}
+
+// WaitListen waits until every server is listening or failed to listen,
+// and returns the listen errors. Servers which do not expose their listeners
+// are not waited, nor are the servers still starting when timeout passes.
+func WaitListen(servers []Server, timeout time.Duration) error {
+	var pending []*Listeners
+	for _, s := range servers {
+		if s, ok := s.(interface{ Listeners() *Listeners }); ok {
+			pending = append(pending, s.Listeners())
+		}
+	}
+
+	var errs []error
+	deadline := time.Now().Add(timeout)
+	for len(pending) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		pending = slices.DeleteFunc(pending, func(ls *Listeners) bool {
+			if err := ls.Err(); err != nil {
+				errs = append(errs, err)
+				return true
+			}
+			return ls.Listening()
+		})
+	}
+	return errors.Join(errs...)
 }
 
 // PacketServer interface.
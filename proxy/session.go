@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nadoo/glider/pkg/log"
+	"github.com/nadoo/glider/pkg/metrics"
+)
+
+var (
+	connsTotal = metrics.NewCounterVec("glider_connections_total",
+		"Total number of proxied tcp connections and udp sessions.", "server", "network")
+	connsActive = metrics.NewGaugeVec("glider_connections_active",
+		"Number of active proxied tcp connections and udp sessions.", "server", "network")
+	bytesTotal = metrics.NewCounterVec("glider_forwarder_bytes_total",
+		"Total bytes relayed via forwarders.", "forwarder", "direction")
+)
+
+// sessions are the active sessions.
+var sessions = struct {
+	sync.Mutex
+	m map[*Session]struct{}
+}{m: make(map[*Session]struct{})}
+
+// Session is a proxied tcp connection or udp session.
+type Session struct {
+	// Server is the scheme of the server which serves the session.
+	Server string
+	// Network is the network of the session, "tcp" or "udp".
+	Network string
+	// Metadata is the source context of the session.
+	Metadata
+	// Target is the address of the destination.
+	Target string
+	// Dialer is the TCPDialer or UDPDialer used to connect the target.
+	Dialer interface{ Addr() string }
+	// Start is the start time of the session.
+	Start time.Time
+	// Err is the error which ended the session, if any.
+	Err error
+
+	up, down atomic.Int64
+
+	mu         sync.Mutex
+	conns      []io.Closer
+	terminated bool
+}
+
+// NewSession returns a new session from src to target served by server.
+func NewSession(server, network string, src net.Addr, target string) *Session {
+	connsTotal.With(server, network).Inc()
+	connsActive.With(server, network).Inc()
+
+	s := &Session{Server: server, Network: network, Metadata: Metadata{Src: src}, Target: target, Start: time.Now()}
+
+	sessions.Lock()
+	sessions.m[s] = struct{}{}
+	sessions.Unlock()
+
+	return s
+}
+
+// TunnelTarget returns the target of the sessions of tunnel servers,
+// which is the forward address, the last hop of dialer.
+func TunnelTarget(dialer interface{ Addr() string }) string {
+	if dialer == nil {
+		return ""
+	}
+	addr := dialer.Addr()
+	if i := strings.LastIndexByte(addr, ','); i >= 0 {
+		return addr[i+1:]
+	}
+	return addr
+}
+
+// Track adds conns which will be closed if the session is terminated by Shutdown.
+func (s *Session) Track(conns ...io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.terminated {
+		for _, c := range conns {
+			c.Close()
+		}
+		return
+	}
+	s.conns = append(s.conns, conns...)
+}
+
+// terminate closes the tracked conns of session.
+func (s *Session) terminate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns, s.terminated = nil, true
+}
+
+// Relay relays between left and right and records the bytes relayed,
+// left should be the client side conn.
+func (s *Session) Relay(left, right net.Conn) error {
+	s.Track(left, right)
+	up, down, err := relay(left, right)
+	s.AddUp(up)
+	s.AddDown(down)
+	if err != nil {
+		s.Err = err
+	}
+	return err
+}
+
+// AddUp adds n bytes sent from client to target.
+func (s *Session) AddUp(n int64) { s.up.Add(n) }
+
+// AddDown adds n bytes sent from target to client.
+func (s *Session) AddDown(n int64) { s.down.Add(n) }
+
+// BytesUp returns the bytes sent from client to target.
+func (s *Session) BytesUp() int64 { return s.up.Load() }
+
+// BytesDown returns the bytes sent from target to client.
+func (s *Session) BytesDown() int64 { return s.down.Load() }
+
+// Forwarder returns the address of the dialer, empty if not dialed.
+func (s *Session) Forwarder() string {
+	if s.Dialer == nil {
+		return ""
+	}
+	return s.Dialer.Addr()
+}
+
+// RuleGroup returns the name of the forwarder group which the dialer belongs to.
+func (s *Session) RuleGroup() string {
+	if g, ok := s.Dialer.(interface{ Group() string }); ok {
+		return g.Group()
+	}
+	return ""
+}
+
+// Close closes the session, records its stats and logs the connection event.
+func (s *Session) Close() {
+	sessions.Lock()
+	delete(sessions.m, s)
+	sessions.Unlock()
+
+	connsActive.With(s.Server, s.Network).Dec()
+	if s.Dialer != nil {
+		bytesTotal.With(s.Dialer.Addr(), "up").Add(float64(s.BytesUp()))
+		bytesTotal.With(s.Dialer.Addr(), "down").Add(float64(s.BytesDown()))
+	}
+
+	if l := accessLog.Load(); l != nil {
+		if err := l.Write(s); err != nil {
+			log.F("[accesslog] write error: %v", err)
+		}
+	}
+
+	if !log.Enabled(log.LevelInfo) {
+		return
+	}
+
+	args := []any{
+		"listener", s.Server,
+		"network", s.Network,
+		"client", s.Src.String(),
+		"target", s.Target,
+		"forwarder", s.Forwarder(),
+		"rule_group", s.RuleGroup(),
+		"bytes_up", s.BytesUp(),
+		"bytes_down", s.BytesDown(),
+		"duration", time.Since(s.Start),
+	}
+	if s.User != "" {
+		args = append(args, "user", s.User)
+	}
+	if s.Err != nil {
+		args = append(args, "error", s.Err.Error())
+	}
+	log.Info("connection closed", args...)
+}
+
+// ActiveSessions returns the number of active sessions.
+func ActiveSessions() int {
+	sessions.Lock()
+	defer sessions.Unlock()
+	return len(sessions.m)
+}
+
+// Shutdown waits for the active sessions to finish until ctx is done,
+// then terminates the remaining sessions by closing their conns.
+// servers should be closed before calling Shutdown to stop accepting new sessions.
+func Shutdown(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for ActiveSessions() > 0 {
+		select {
+		case <-ctx.Done():
+			sessions.Lock()
+			for s := range sessions.m {
+				s.terminate()
+			}
+			sessions.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
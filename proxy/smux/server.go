@@ -1,6 +1,7 @@
 package smux
 
 import (
+	"fmt"
 	"net"
 	"net/url"
 	"strings"
@@ -15,6 +16,8 @@ type SmuxServer struct {
 	proxy  proxy.Proxy
 	addr   string
 	server proxy.Server
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -49,7 +52,10 @@ func NewSmuxServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *SmuxServer) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[smux] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[smux] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -59,6 +65,9 @@ func (s *SmuxServer) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[smux] failed to accept: %v", err)
 			continue
 		}
@@ -67,6 +76,12 @@ func (s *SmuxServer) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *SmuxServer) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *SmuxServer) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves a connection.
 func (s *SmuxServer) Serve(c net.Conn) {
 	// we know the internal server will close the connection after serve
@@ -98,18 +113,20 @@ func (s *SmuxServer) ServeStream(c *smux.Stream) {
 
 	defer c.Close()
 
-	rc, dialer, err := s.proxy.Dial("tcp", "")
+	sess := proxy.NewSession("smux", "tcp", c.RemoteAddr(), "")
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", "")
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[smux] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), s.addr, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		s.proxy.Record(dialer, false)
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[smux] %s <-> %s", c.RemoteAddr(), dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[smux] %s <-> %s, relay error: %v", c.RemoteAddr(), dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
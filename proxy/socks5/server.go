@@ -2,6 +2,7 @@ package socks5
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"strings"
@@ -31,11 +32,20 @@ func (s *Socks5) ListenAndServe() {
 	s.ListenAndServeTCP()
 }
 
+// Close closes the listeners of the server.
+func (s *Socks5) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *Socks5) Listeners() *proxy.Listeners { return &s.listeners }
+
 // ListenAndServeTCP listen and serve on tcp port.
 func (s *Socks5) ListenAndServeTCP() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[socks5] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[socks5] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 
@@ -44,6 +54,9 @@ func (s *Socks5) ListenAndServeTCP() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[socks5] failed to accept: %v", err)
 			continue
 		}
@@ -60,7 +73,7 @@ func (s *Socks5) Serve(c net.Conn) {
 		c.SetKeepAlive(true)
 	}
 
-	tgt, err := s.handshake(c)
+	tgt, user, err := s.handshake(c)
 	if err != nil {
 		// UDP: keep the connection until disconnect then free the UDP socket
 		if err == socks.Errors[9] {
@@ -81,17 +94,29 @@ func (s *Socks5) Serve(c net.Conn) {
 		return
 	}
 
-	rc, dialer, err := s.proxy.Dial("tcp", tgt.String())
+	sess := proxy.NewSession("socks5", "tcp", c.RemoteAddr(), tgt.String())
+	sess.User = user
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", tgt.String())
 	if err != nil {
-		log.F("[socks5] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
+		rep := byte(1) // general SOCKS server failure
+		if errors.Is(err, proxy.ErrBlocked) {
+			rep = 2 // connection not allowed by ruleset
+		}
+		c.Write([]byte{Version, rep, 0, 1, 0, 0, 0, 0, 0, 0})
+		sess.Dialer, sess.Err = dialer, err
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[socks5] %s <-> %s via %s", c.RemoteAddr(), tgt, dialer.Addr())
+	if _, err = c.Write([]byte{Version, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil { // SOCKS v5, reply succeeded
+		sess.Err = err
+		return
+	}
 
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[socks5] %s <-> %s via %s, relay error: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -103,7 +128,10 @@ func (s *Socks5) Serve(c net.Conn) {
 func (s *Socks5) ListenAndServeUDP() {
 	lc, err := net.ListenPacket("udp", s.addr)
 	if err != nil {
-		log.Fatalf("[socks5] failed to listen on UDP %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[socks5] failed to listen on UDP %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(lc); err != nil {
 		return
 	}
 	defer lc.Close()
@@ -121,6 +149,9 @@ func (s *Socks5) ServePacket(pc net.PacketConn) {
 
 		n, srcAddr, dstAddr, err := c.readFrom(buf)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.F("[socks5u] remote read error: %v", err)
 			continue
 		}
@@ -142,26 +173,31 @@ func (s *Socks5) ServePacket(pc net.PacketConn) {
 }
 
 func (s *Socks5) serveSession(session *Session) {
-	dstPC, dialer, err := s.proxy.DialUDP("udp", session.srcPC.target.String())
+	sess := proxy.NewSession("socks5", "udp", session.src, session.srcPC.target.String())
+	defer sess.Close()
+
+	dstPC, dialer, err := s.proxy.DialUDP(&sess.Metadata, "udp", session.srcPC.target.String())
 	if err != nil {
-		log.F("[socks5u] remote dial error: %v", err)
+		sess.Dialer, sess.Err = dialer, err
 		nm.Delete(session.key)
 		return
 	}
 	defer dstPC.Close()
+	sess.Dialer = dialer
+	sess.Track(dstPC)
 
 	go func() {
-		proxy.CopyUDP(session.srcPC, nil, dstPC, 2*time.Minute, 5*time.Second)
+		n, _ := proxy.CopyUDP(session.srcPC, nil, dstPC, 2*time.Minute, 5*time.Second)
+		sess.AddDown(n)
 		nm.Delete(session.key)
 		close(session.finCh)
 	}()
 
-	log.F("[socks5u] %s <-> %s via %s", session.src, session.srcPC.target, dialer.Addr())
-
 	for {
 		select {
 		case msg := <-session.msgCh:
-			_, err = dstPC.WriteTo(msg.msg, msg.dst)
+			n, err := dstPC.WriteTo(msg.msg, msg.dst)
+			sess.AddUp(int64(n))
 			if err != nil {
 				log.F("[socks5u] writeTo %s error: %v", msg.dst, err)
 			}
@@ -192,95 +228,109 @@ func newSession(key string, src, dst net.Addr, srcPC *PktConn) *Session {
 	return &Session{key, src, dst, srcPC, make(chan message, 32), make(chan struct{})}
 }
 
-// Handshake fast-tracks SOCKS initialization to get target address to connect.
-func (s *Socks5) handshake(c net.Conn) (socks.Addr, error) {
+// authRequired reports whether the clients need to be authenticated.
+func (s *Socks5) authRequired() bool {
+	return s.users != nil || (s.user != "" && s.password != "")
+}
+
+// verify verifies the user credentials, the users file takes precedence.
+func (s *Socks5) verify(user, pass string) bool {
+	if s.users != nil {
+		return s.users.Verify(user, pass)
+	}
+	return user == s.user && pass == s.password
+}
+
+// Handshake fast-tracks SOCKS initialization to get target address to connect
+// and the authenticated user, the reply of CONNECT command is left to the caller.
+func (s *Socks5) handshake(c net.Conn) (addr socks.Addr, user string, err error) {
 	// Read RFC 1928 for request and reply structure and sizes
 	buf := pool.GetBuffer(socks.MaxAddrLen)
 	defer pool.PutBuffer(buf)
 
 	// read VER, NMETHODS, METHODS
 	if _, err := io.ReadFull(c, buf[:2]); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	nmethods := buf[1]
 	if _, err := io.ReadFull(c, buf[:nmethods]); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// write VER METHOD
-	if s.user != "" && s.password != "" {
+	if s.authRequired() {
 		_, err := c.Write([]byte{Version, socks.AuthPassword})
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		_, err = io.ReadFull(c, buf[:2])
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 		// Get username
 		userLen := int(buf[1])
 		if userLen <= 0 {
 			c.Write([]byte{1, 1})
-			return nil, errors.New("auth failed: wrong username length")
+			return nil, "", errors.New("auth failed: wrong username length")
 		}
 
 		if _, err := io.ReadFull(c, buf[:userLen]); err != nil {
-			return nil, errors.New("auth failed: cannot get username")
+			return nil, "", errors.New("auth failed: cannot get username")
 		}
-		user := string(buf[:userLen])
+		user = string(buf[:userLen])
 
 		// Get password
 		_, err = c.Read(buf[:1])
 		if err != nil {
-			return nil, errors.New("auth failed: cannot get password len")
+			return nil, "", errors.New("auth failed: cannot get password len")
 		}
 
 		passLen := int(buf[0])
 		if passLen <= 0 {
 			c.Write([]byte{1, 1})
-			return nil, errors.New("auth failed: wrong password length")
+			return nil, "", errors.New("auth failed: wrong password length")
 		}
 
 		_, err = io.ReadFull(c, buf[:passLen])
 		if err != nil {
-			return nil, errors.New("auth failed: cannot get password")
+			return nil, "", errors.New("auth failed: cannot get password")
 		}
 		pass := string(buf[:passLen])
 
 		// Verify
-		if user != s.user || pass != s.password {
+		if !s.verify(user, pass) {
 			_, err = c.Write([]byte{1, 1})
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
-			return nil, errors.New("auth failed, authinfo: " + user + ":" + pass)
+			return nil, "", errors.New("auth failed, user: " + user)
 		}
 
 		// Response auth state
 		_, err = c.Write([]byte{1, 0})
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 
 	} else if _, err := c.Write([]byte{Version, socks.AuthNone}); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// read VER CMD RSV ATYP DST.ADDR DST.PORT
 	if _, err := io.ReadFull(c, buf[:3]); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	cmd := buf[1]
-	addr, err := socks.ReadAddr(c)
+	addr, err = socks.ReadAddr(c)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	switch cmd {
 	case socks.CmdConnect:
-		_, err = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) // SOCKS v5, reply succeeded
+		// replied after the target is dialed, so the client can know the dial result.
 	case socks.CmdUDPAssociate:
 		listenAddr := socks.ParseAddr(c.LocalAddr().String())
 		if listenAddr == nil { // maybe it's unix socket
@@ -288,12 +338,12 @@ func (s *Socks5) handshake(c net.Conn) (socks.Addr, error) {
 		}
 		_, err = c.Write(append([]byte{5, 0, 0}, listenAddr...)) // SOCKS v5, reply succeeded
 		if err != nil {
-			return nil, socks.Errors[7]
+			return nil, "", socks.Errors[7]
 		}
 		err = socks.Errors[9]
 	default:
-		return nil, socks.Errors[7]
+		return nil, "", socks.Errors[7]
 	}
 
-	return addr, err // skip VER, CMD, RSV fields
+	return addr, user, err // skip VER, CMD, RSV fields
 }
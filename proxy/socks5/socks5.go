@@ -12,6 +12,7 @@ package socks5
 import (
 	"net/url"
 
+	"github.com/nadoo/glider/pkg/auth"
 	"github.com/nadoo/glider/pkg/log"
 	"github.com/nadoo/glider/proxy"
 )
@@ -26,6 +27,9 @@ type Socks5 struct {
 	addr     string
 	user     string
 	password string
+	users    *auth.Users
+
+	listeners proxy.Listeners
 }
 
 // NewSocks5 returns a Proxy that makes SOCKS v5 connections to the given address.
@@ -49,12 +53,22 @@ func NewSocks5(s string, d proxy.Dialer, p proxy.Proxy) (*Socks5, error) {
 		password: pass,
 	}
 
+	if path := u.Query().Get("users"); path != "" {
+		h.users, err = auth.NewUsers(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return h, nil
 }
 
 func init() {
 	proxy.AddUsage("socks5", `
 Socks5 scheme:
-  socks5://[user:pass@]host:port
+  socks5://[user:pass@]host:port[?users=PATH]
+
+  users: users file of server, one USER:HASHED_PASS per line, hashed by bcrypt or argon2
+         (e.g. htpasswd -nB USER), reloaded when changed.
 `)
 }
@@ -1,6 +1,8 @@
 package ss
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"strings"
@@ -26,11 +28,20 @@ func (s *SS) ListenAndServe() {
 	s.ListenAndServeTCP()
 }
 
+// Close closes the listeners of the server.
+func (s *SS) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *SS) Listeners() *proxy.Listeners { return &s.listeners }
+
 // ListenAndServeTCP serves tcp ss requests.
 func (s *SS) ListenAndServeTCP() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[ss] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[ss] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 
@@ -39,6 +50,9 @@ func (s *SS) ListenAndServeTCP() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[ss] failed to accept: %v", err)
 			continue
 		}
@@ -64,18 +78,18 @@ func (s *SS) Serve(c net.Conn) {
 		return
 	}
 
-	dialer := s.proxy.NextDialer(tgt.String())
-	rc, err := dialer.Dial("tcp", tgt.String())
+	sess := proxy.NewSession("ss", "tcp", c.RemoteAddr(), tgt.String())
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", tgt.String())
 	if err != nil {
-		log.F("[ss] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[ss] %s <-> %s via %s", c.RemoteAddr(), tgt, dialer.Addr())
-
-	if err = proxy.Relay(sc, rc); err != nil {
-		log.F("[ss] %s <-> %s via %s, relay error: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
+	if err = sess.Relay(sc, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -87,7 +101,10 @@ func (s *SS) Serve(c net.Conn) {
 func (s *SS) ListenAndServeUDP() {
 	lc, err := net.ListenPacket("udp", s.addr)
 	if err != nil {
-		log.Fatalf("[ss] failed to listen on UDP %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[ss] failed to listen on UDP %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(lc); err != nil {
 		return
 	}
 	defer lc.Close()
@@ -106,6 +123,9 @@ func (s *SS) ServePacket(pc net.PacketConn) {
 
 		n, srcAddr, dstAddr, err := c.readFrom(buf)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			log.F("[ssu] remote read error: %v", err)
 			continue
 		}
@@ -127,26 +147,31 @@ func (s *SS) ServePacket(pc net.PacketConn) {
 }
 
 func (s *SS) serveSession(session *Session) {
-	dstPC, dialer, err := s.proxy.DialUDP("udp", session.dst.String())
+	sess := proxy.NewSession("ss", "udp", session.src, session.dst.String())
+	defer sess.Close()
+
+	dstPC, dialer, err := s.proxy.DialUDP(&sess.Metadata, "udp", session.dst.String())
 	if err != nil {
-		log.F("[ssu] remote dial error: %v", err)
+		sess.Dialer, sess.Err = dialer, err
 		nm.Delete(session.key)
 		return
 	}
 	defer dstPC.Close()
+	sess.Dialer = dialer
+	sess.Track(dstPC)
 
 	go func() {
-		proxy.CopyUDP(session.srcPC, nil, dstPC, 2*time.Minute, 5*time.Second)
+		n, _ := proxy.CopyUDP(session.srcPC, nil, dstPC, 2*time.Minute, 5*time.Second)
+		sess.AddDown(n)
 		nm.Delete(session.key)
 		close(session.finCh)
 	}()
 
-	log.F("[ssu] %s <-> %s via %s", session.src, session.dst, dialer.Addr())
-
 	for {
 		select {
 		case msg := <-session.msgCh:
-			_, err = dstPC.WriteTo(msg.msg, msg.dst)
+			n, err := dstPC.WriteTo(msg.msg, msg.dst)
+			sess.AddUp(int64(n))
 			if err != nil {
 				log.F("[ssu] writeTo %s error: %v", msg.dst, err)
 			}
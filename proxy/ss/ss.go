@@ -15,6 +15,8 @@ type SS struct {
 	addr   string
 
 	cipher.Cipher
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -36,7 +38,8 @@ func NewSS(s string, d proxy.Dialer, p proxy.Proxy) (*SS, error) {
 
 	ciph, err := cipher.PickCipher(method, nil, pass)
 	if err != nil {
-		log.Fatalf("[ss] PickCipher for '%s', error: %s", method, err)
+		log.F("[ss] PickCipher for '%s', error: %s", method, err)
+		return nil, err
 	}
 
 	ss := &SS{
@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"fmt"
 	"net"
 	"net/url"
 	"strings"
@@ -14,6 +15,8 @@ type TCP struct {
 	addr   string
 	dialer proxy.Dialer
 	proxy  proxy.Proxy
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -52,7 +55,10 @@ func NewTCPServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *TCP) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[tcp] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[tcp] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -62,6 +68,9 @@ func (s *TCP) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[tcp] failed to accept: %v", err)
 			continue
 		}
@@ -70,6 +79,12 @@ func (s *TCP) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *TCP) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *TCP) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves a connection.
 func (s *TCP) Serve(c net.Conn) {
 	defer c.Close()
@@ -78,18 +93,20 @@ func (s *TCP) Serve(c net.Conn) {
 		c.SetKeepAlive(true)
 	}
 
-	rc, dialer, err := s.proxy.Dial("tcp", "")
+	sess := proxy.NewSession("tcp", "tcp", c.RemoteAddr(), "")
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", "")
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[tcp] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), s.addr, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		s.proxy.Record(dialer, false)
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[tcp] %s <-> %s", c.RemoteAddr(), dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[tcp] %s <-> %s, relay error: %v", c.RemoteAddr(), dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -31,6 +31,8 @@ type TLS struct {
 	alpn []string
 
 	server proxy.Server
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -138,7 +140,10 @@ func NewTLSServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *TLS) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[tls] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[tls] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -148,6 +153,9 @@ func (s *TLS) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[tls] failed to accept: %v", err)
 			continue
 		}
@@ -156,6 +164,12 @@ func (s *TLS) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *TLS) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *TLS) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves a connection.
 func (s *TLS) Serve(cc net.Conn) {
 	c := stdtls.Server(cc, s.config)
@@ -167,18 +181,20 @@ func (s *TLS) Serve(cc net.Conn) {
 
 	defer c.Close()
 
-	rc, dialer, err := s.proxy.Dial("tcp", "")
+	sess := proxy.NewSession("tls", "tcp", c.RemoteAddr(), "")
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", "")
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[tls] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), s.addr, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		s.proxy.Record(dialer, false)
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[tls] %s <-> %s", c.RemoteAddr(), dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[tls] %s <-> %s, relay error: %v", c.RemoteAddr(), dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -3,6 +3,7 @@
 package tproxy
 
 import (
+	"fmt"
 	"net"
 	"net/url"
 	"sync"
@@ -23,6 +24,8 @@ func init() {
 type TProxy struct {
 	proxy proxy.Proxy
 	addr  string
+
+	listeners proxy.Listeners
 }
 
 // NewTProxy returns a tproxy.
@@ -52,6 +55,12 @@ func (s *TProxy) ListenAndServe() {
 	s.ListenAndServeUDP()
 }
 
+// Close closes the listeners of the server.
+func (s *TProxy) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *TProxy) Listeners() *proxy.Listeners { return &s.listeners }
+
 // ListenAndServeTCP listens and serves tcp.
 func (s *TProxy) ListenAndServeTCP() {
 	log.F("[tproxy] tcp mode not supported now, please use 'redir' instead")
@@ -66,13 +75,16 @@ func (s *TProxy) Serve(c net.Conn) {
 func (s *TProxy) ListenAndServeUDP() {
 	laddr, err := net.ResolveUDPAddr("udp", s.addr)
 	if err != nil {
-		log.Fatalf("[tproxyu] failed to resolve addr %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[tproxyu] failed to resolve addr %s: %w", s.addr, err))
 		return
 	}
 
 	lc, err := ListenUDP("udp", laddr)
 	if err != nil {
-		log.F("[tproxyu] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[tproxyu] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(lc); err != nil {
 		return
 	}
 	defer lc.Close()
@@ -83,6 +95,9 @@ func (s *TProxy) ListenAndServeUDP() {
 		buf := pool.GetBuffer(proxy.UDPBufSize)
 		n, srcAddr, dstAddr, err := ReadFromUDP(lc, buf)
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[tproxyu] read error: %v", err)
 			continue
 		}
@@ -105,13 +120,18 @@ func (s *TProxy) ListenAndServeUDP() {
 
 // serveSession serves a udp session.
 func (s *TProxy) serveSession(session *session) {
-	dstPC, dialer, err := s.proxy.DialUDP("udp", session.dst.String())
+	sess := proxy.NewSession("tproxy", "udp", session.src, session.dst.String())
+	defer sess.Close()
+
+	dstPC, dialer, err := s.proxy.DialUDP(&sess.Metadata, "udp", session.dst.String())
 	if err != nil {
-		log.F("[tproxyu] dial to %s error: %v", session.dst, err)
+		sess.Dialer, sess.Err = dialer, err
 		nm.Delete(session.key)
 		return
 	}
 	defer dstPC.Close()
+	sess.Dialer = dialer
+	sess.Track(dstPC)
 
 	go func() {
 		timeout, step := 2*time.Minute, 5*time.Second
@@ -142,8 +162,9 @@ func (s *TProxy) serveSession(session *session) {
 				break
 			}
 
-			_, err = srcPC.WriteTo(buf[:n], session.src)
+			n, err = srcPC.WriteTo(buf[:n], session.src)
 			srcPC.Close()
+			sess.AddDown(int64(n))
 
 			if err != nil {
 				break
@@ -154,12 +175,11 @@ func (s *TProxy) serveSession(session *session) {
 		close(session.finCh)
 	}()
 
-	log.F("[tproxyu] %s <-> %s via %s", session.src, session.dst, dialer.Addr())
-
 	for {
 		select {
 		case msg := <-session.msgCh:
-			_, err = dstPC.WriteTo(msg.msg, msg.dst)
+			n, err := dstPC.WriteTo(msg.msg, msg.dst)
+			sess.AddUp(int64(n))
 			if err != nil {
 				log.F("[tproxyu] writeTo %s error: %v", msg.dst, err)
 			}
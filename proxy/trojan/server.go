@@ -61,7 +61,10 @@ func NewTrojanServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *Trojan) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[trojan] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[trojan] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -71,6 +74,9 @@ func (s *Trojan) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[trojan] failed to accept: %v", err)
 			continue
 		}
@@ -79,6 +85,12 @@ func (s *Trojan) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *Trojan) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *Trojan) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves a connection.
 func (s *Trojan) Serve(c net.Conn) {
 	if c, ok := c.(*net.TCPConn); ok {
@@ -109,28 +121,35 @@ func (s *Trojan) Serve(c net.Conn) {
 	}
 
 	network := "tcp"
-	dialer := s.proxy.NextDialer(target.String())
-
 	if cmd == socks.CmdUDPAssociate {
-		// there is no upstream proxy, just serve it
-		if dialer.Addr() == "DIRECT" {
-			s.ServeUoT(c, target)
-			return
-		}
 		network = "udp"
 	}
 
+	meta := &proxy.Metadata{Src: c.RemoteAddr()}
+	dialer := s.proxy.NextDialer(meta, network, target.String())
+
+	// there is no upstream proxy, just serve it
+	if network == "udp" && dialer.Addr() == "DIRECT" {
+		s.ServeUoT(c, target, dialer)
+		return
+	}
+
+	sess := proxy.NewSession("trojan", network, c.RemoteAddr(), target.String())
+	sess.Metadata = *meta
+	sess.Dialer = dialer
+	defer sess.Close()
+
 	rc, err := dialer.Dial(network, target.String())
 	if err != nil {
-		log.F("[trojan] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), target, dialer.Addr(), err)
+		if errors.Is(err, proxy.ErrBlocked) {
+			proxy.LogBlocked(sess.RuleGroup(), network, sess.Src.String(), target.String())
+		}
+		sess.Err = err
 		return
 	}
 	defer rc.Close()
 
-	log.F("[trojan] %s <-> %s via %s", c.RemoteAddr(), target, dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[trojan] %s <-> %s via %s, relay error: %v", c.RemoteAddr(), target, dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -139,26 +158,28 @@ func (s *Trojan) Serve(c net.Conn) {
 }
 
 func (s *Trojan) serveFallback(c net.Conn, tgt string, headBuf *bytes.Buffer) {
+	sess := proxy.NewSession("trojan", "tcp", c.RemoteAddr(), tgt)
+	defer sess.Close()
+
 	// TODO: should we access fallback directly or via proxy?
-	dialer := s.proxy.NextDialer(tgt)
-	rc, err := dialer.Dial("tcp", tgt)
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", tgt)
 	if err != nil {
-		log.F("[trojan-fallback] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	_, err = rc.Write(headBuf.Bytes())
+	n, err := rc.Write(headBuf.Bytes())
+	sess.AddUp(int64(n))
 	if err != nil {
-		log.F("[trojan-fallback] write to rc error: %v", err)
+		sess.Err = err
 		return
 	}
 
-	log.F("[trojan-fallback] %s <-> %s via %s", c.RemoteAddr(), tgt, dialer.Addr())
+	log.Debug("[trojan] fallback", "client", c.RemoteAddr().String(), "target", tgt, "forwarder", dialer.Addr())
 
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[trojan-fallback] %s <-> %s via %s, relay error: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
-	}
+	sess.Relay(c, rc)
 }
 
 func (s *Trojan) readHeader(r io.Reader) (byte, socks.Addr, error) {
@@ -193,7 +214,11 @@ func (s *Trojan) readHeader(r io.Reader) (byte, socks.Addr, error) {
 }
 
 // ServeUoT serves udp over tcp requests.
-func (s *Trojan) ServeUoT(c net.Conn, tgt socks.Addr) {
+func (s *Trojan) ServeUoT(c net.Conn, tgt socks.Addr, dialer proxy.Dialer) {
+	sess := proxy.NewSession("trojan", "udp", c.RemoteAddr(), tgt.String())
+	sess.Dialer = dialer
+	defer sess.Close()
+
 	lc, err := net.ListenPacket("udp", "")
 	if err != nil {
 		log.F("[trojan] UDP listen error: %v", err)
@@ -202,8 +227,18 @@ func (s *Trojan) ServeUoT(c net.Conn, tgt socks.Addr) {
 	defer lc.Close()
 
 	pc := NewPktConn(c, tgt)
-	log.F("[trojan] %s <-UoT-> %s <-> %s", c.RemoteAddr(), lc.LocalAddr(), tgt)
+	sess.Track(c, lc)
+
+	done := make(chan struct{})
+	go func() {
+		n, _ := proxy.CopyUDP(lc, nil, pc, 2*time.Minute, 5*time.Second)
+		sess.AddUp(n)
+		close(done)
+	}()
+
+	n, _ := proxy.CopyUDP(pc, nil, lc, 2*time.Minute, 5*time.Second)
+	sess.AddDown(n)
 
-	go proxy.CopyUDP(lc, nil, pc, 2*time.Minute, 5*time.Second)
-	proxy.CopyUDP(pc, nil, lc, 2*time.Minute, 5*time.Second)
+	c.SetReadDeadline(time.Now()) // unblock read on c
+	<-done
 }
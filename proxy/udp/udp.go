@@ -1,6 +1,7 @@
 package udp
 
 import (
+	"fmt"
 	"net"
 	"net/url"
 	"sync"
@@ -24,6 +25,8 @@ type UDP struct {
 	uaddr  *net.UDPAddr
 	dialer proxy.Dialer
 	proxy  proxy.Proxy
+
+	listeners proxy.Listeners
 }
 
 // NewUDP returns a udp struct.
@@ -58,7 +61,10 @@ func NewUDPServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *UDP) ListenAndServe() {
 	c, err := net.ListenPacket("udp", s.addr)
 	if err != nil {
-		log.Fatalf("[udp] failed to listen on UDP %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[udp] failed to listen on UDP %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(c); err != nil {
 		return
 	}
 	defer c.Close()
@@ -69,6 +75,9 @@ func (s *UDP) ListenAndServe() {
 		buf := pool.GetBuffer(proxy.UDPBufSize)
 		n, srcAddr, err := c.ReadFrom(buf)
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[udp] read error: %v", err)
 			continue
 		}
@@ -89,29 +98,41 @@ func (s *UDP) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *UDP) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *UDP) Listeners() *proxy.Listeners { return &s.listeners }
+
 func (s *UDP) serveSession(session *session) {
+	sess := proxy.NewSession("udp", "udp", session.src, "")
+	defer sess.Close()
+
 	// we know we are creating an udp tunnel, so the dial addr is meaningless,
 	// we use srcAddr here to help the unix client to identify the source socket.
-	dstPC, dialer, err := s.proxy.DialUDP("udp", session.src.String())
+	dstPC, dialer, err := s.proxy.DialUDP(&sess.Metadata, "udp", session.src.String())
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[udp] remote dial error: %v", err)
+		sess.Dialer, sess.Err = dialer, err
 		nm.Delete(session.key)
 		return
 	}
 	defer dstPC.Close()
+	sess.Dialer = dialer
+	sess.Track(dstPC)
 
 	go func() {
-		proxy.CopyUDP(session, session.src, dstPC, 2*time.Minute, 5*time.Second)
+		n, _ := proxy.CopyUDP(session, session.src, dstPC, 2*time.Minute, 5*time.Second)
+		sess.AddDown(n)
 		nm.Delete(session.key)
 		close(session.finCh)
 	}()
 
-	log.F("[udp] %s <-> %s", session.src, dialer.Addr())
-
 	for {
 		select {
 		case p := <-session.msgCh:
-			_, err = dstPC.WriteTo(p, nil) // we know it's tunnel so dst addr could be nil
+			n, err := dstPC.WriteTo(p, nil)
+			sess.AddUp(int64(n)) // we know it's tunnel so dst addr could be nil
 			if err != nil {
 				log.F("[udp] writeTo error: %v", err)
 			}
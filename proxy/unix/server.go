@@ -1,6 +1,7 @@
 package unix
 
 import (
+	"fmt"
 	"net"
 	"os"
 	"strings"
@@ -42,12 +43,21 @@ func (s *Unix) ListenAndServe() {
 	s.ListenAndServeTCP()
 }
 
+// Close closes the listeners of the server.
+func (s *Unix) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *Unix) Listeners() *proxy.Listeners { return &s.listeners }
+
 // ListenAndServeTCP serves tcp requests.
 func (s *Unix) ListenAndServeTCP() {
 	os.Remove(s.addr)
 	l, err := net.Listen("unix", s.addr)
 	if err != nil {
-		log.Fatalf("[unix] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[unix] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -57,6 +67,9 @@ func (s *Unix) ListenAndServeTCP() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[unix] failed to accept: %v", err)
 			continue
 		}
@@ -74,18 +87,20 @@ func (s *Unix) Serve(c net.Conn) {
 
 	defer c.Close()
 
-	rc, dialer, err := s.proxy.Dial("unix", "")
+	sess := proxy.NewSession("unix", "unix", c.RemoteAddr(), "")
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "unix", "")
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[unix] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), s.addr, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		s.proxy.Record(dialer, false)
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[unix] %s <-> %s", c.RemoteAddr(), dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[unix] %s <-> %s, relay error: %v", c.RemoteAddr(), dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -101,6 +116,9 @@ func (s *Unix) ListenAndServeUDP() {
 		log.F("[unix] failed to ListenPacket on %s: %v", s.addru, err)
 		return
 	}
+	if err := s.listeners.Add(c); err != nil {
+		return
+	}
 	defer c.Close()
 
 	log.F("[unix] ListenPacket on %s", s.addru)
@@ -119,6 +137,9 @@ func (s *Unix) ServePacket(pc net.PacketConn) {
 		buf := pool.GetBuffer(proxy.UDPBufSize)
 		n, srcAddr, err := pc.ReadFrom(buf)
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[unix] read error: %v", err)
 			continue
 		}
@@ -140,26 +161,32 @@ func (s *Unix) ServePacket(pc net.PacketConn) {
 }
 
 func (s *Unix) serveSession(session *Session) {
-	dstPC, dialer, err := s.proxy.DialUDP("udp", "")
+	sess := proxy.NewSession("unix", "udp", session.src, "")
+	defer sess.Close()
+
+	dstPC, dialer, err := s.proxy.DialUDP(&sess.Metadata, "udp", "")
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[unix] remote dial error: %v", err)
+		sess.Dialer, sess.Err = dialer, err
 		nm.Delete(session.key)
 		return
 	}
 	defer dstPC.Close()
+	sess.Dialer = dialer
+	sess.Track(dstPC)
 
 	go func() {
-		proxy.CopyUDP(session.srcPC, session.src, dstPC, 2*time.Minute, 5*time.Second)
+		n, _ := proxy.CopyUDP(session.srcPC, session.src, dstPC, 2*time.Minute, 5*time.Second)
+		sess.AddDown(n)
 		nm.Delete(session.key)
 		close(session.finCh)
 	}()
 
-	log.F("[unix] %s <-> %s", session.src, dialer.Addr())
-
 	for {
 		select {
 		case p := <-session.msgCh:
-			_, err = dstPC.WriteTo(p, nil)
+			n, err := dstPC.WriteTo(p, nil)
+			sess.AddUp(int64(n))
 			if err != nil {
 				log.F("[unix] writeTo error: %v", err)
 			}
@@ -19,6 +19,8 @@ type Unix struct {
 
 	addru  string // addr for udp (datagram)
 	uaddru *net.UnixAddr
+
+	listeners proxy.Listeners
 }
 
 // NewUnix returns unix domain socket proxy.
@@ -2,6 +2,7 @@ package vless
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -22,7 +23,10 @@ func NewVLessServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *VLess) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[vless] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[vless] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -32,6 +36,9 @@ func (s *VLess) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[vless] failed to accept: %v", err)
 			continue
 		}
@@ -40,6 +47,12 @@ func (s *VLess) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *VLess) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *VLess) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves a connection.
 func (s *VLess) Serve(c net.Conn) {
 	defer c.Close()
@@ -63,28 +76,35 @@ func (s *VLess) Serve(c net.Conn) {
 	c = NewServerConn(c)
 
 	network := "tcp"
-	dialer := s.proxy.NextDialer(target)
-
 	if cmd == CmdUDP {
-		// there is no upstream proxy, just serve it
-		if dialer.Addr() == "DIRECT" {
-			s.ServeUoT(c, target)
-			return
-		}
 		network = "udp"
 	}
 
+	meta := &proxy.Metadata{Src: c.RemoteAddr()}
+	dialer := s.proxy.NextDialer(meta, network, target)
+
+	// there is no upstream proxy, just serve it
+	if network == "udp" && dialer.Addr() == "DIRECT" {
+		s.ServeUoT(c, target, dialer)
+		return
+	}
+
+	sess := proxy.NewSession("vless", network, c.RemoteAddr(), target)
+	sess.Metadata = *meta
+	sess.Dialer = dialer
+	defer sess.Close()
+
 	rc, err := dialer.Dial(network, target)
 	if err != nil {
-		log.F("[vless] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), target, dialer.Addr(), err)
+		if errors.Is(err, proxy.ErrBlocked) {
+			proxy.LogBlocked(sess.RuleGroup(), network, sess.Src.String(), target)
+		}
+		sess.Err = err
 		return
 	}
 	defer rc.Close()
 
-	log.F("[vless] %s <-> %s via %s", c.RemoteAddr(), target, dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[vless] %s <-> %s via %s, relay error: %v", c.RemoteAddr(), target, dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -93,26 +113,28 @@ func (s *VLess) Serve(c net.Conn) {
 }
 
 func (s *VLess) serveFallback(c net.Conn, tgt string, headBuf *bytes.Buffer) {
+	sess := proxy.NewSession("vless", "tcp", c.RemoteAddr(), tgt)
+	defer sess.Close()
+
 	// TODO: should we access fallback directly or via proxy?
-	dialer := s.proxy.NextDialer(tgt)
-	rc, err := dialer.Dial("tcp", tgt)
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", tgt)
 	if err != nil {
-		log.F("[vless-fallback] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	_, err = rc.Write(headBuf.Bytes())
+	n, err := rc.Write(headBuf.Bytes())
+	sess.AddUp(int64(n))
 	if err != nil {
-		log.F("[vless-fallback] write to rc error: %v", err)
+		sess.Err = err
 		return
 	}
 
-	log.F("[vless-fallback] %s <-> %s via %s", c.RemoteAddr(), tgt, dialer.Addr())
+	log.Debug("[vless] fallback", "client", c.RemoteAddr().String(), "target", tgt, "forwarder", dialer.Addr())
 
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[vless-fallback] %s <-> %s via %s, relay error: %v", c.RemoteAddr(), tgt, dialer.Addr(), err)
-	}
+	sess.Relay(c, rc)
 }
 
 func (s *VLess) readHeader(r io.Reader) (CmdType, string, error) {
@@ -148,7 +170,11 @@ func (s *VLess) readHeader(r io.Reader) (CmdType, string, error) {
 }
 
 // ServeUoT serves udp over tcp requests.
-func (s *VLess) ServeUoT(c net.Conn, tgt string) {
+func (s *VLess) ServeUoT(c net.Conn, tgt string, dialer proxy.Dialer) {
+	sess := proxy.NewSession("vless", "udp", c.RemoteAddr(), tgt)
+	sess.Dialer = dialer
+	defer sess.Close()
+
 	rc, err := net.ListenPacket("udp", "")
 	if err != nil {
 		log.F("[vless] UDP listen error: %v", err)
@@ -163,10 +189,20 @@ func (s *VLess) ServeUoT(c net.Conn, tgt string) {
 	}
 
 	pc := NewPktConn(c, tgtAddr)
-	log.F("[vless] %s <-UoT-> %s <-> %s", c.RemoteAddr(), rc.LocalAddr(), tgt)
+	sess.Track(c, rc)
+
+	done := make(chan struct{})
+	go func() {
+		n, _ := proxy.CopyUDP(rc, nil, pc, 2*time.Minute, 5*time.Second)
+		sess.AddUp(n)
+		close(done)
+	}()
+
+	n, _ := proxy.CopyUDP(pc, nil, rc, 2*time.Minute, 5*time.Second)
+	sess.AddDown(n)
 
-	go proxy.CopyUDP(rc, nil, pc, 2*time.Minute, 5*time.Second)
-	proxy.CopyUDP(pc, nil, rc, 2*time.Minute, 5*time.Second)
+	c.SetReadDeadline(time.Now()) // unblock read on c
+	<-done
 }
 
 // ServerConn is a vless client connection.
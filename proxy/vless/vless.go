@@ -30,6 +30,8 @@ type VLess struct {
 	addr     string
 	uuid     [16]byte
 	fallback string
+
+	listeners proxy.Listeners
 }
 
 func init() {
@@ -3,6 +3,7 @@
 package vsock
 
 import (
+	"fmt"
 	"net"
 	"strings"
 
@@ -44,7 +45,10 @@ func NewVSockServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *vsock) ListenAndServe() {
 	l, err := Listen(s.cid, s.port)
 	if err != nil {
-		log.Fatalf("[vsock] failed to listen: %v", err)
+		s.listeners.Fail(fmt.Errorf("[vsock] failed to listen: %w", err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -54,6 +58,9 @@ func (s *vsock) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[vsock] failed to accept: %v", err)
 			continue
 		}
@@ -62,6 +69,12 @@ func (s *vsock) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *vsock) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *vsock) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves requests.
 func (s *vsock) Serve(c net.Conn) {
 	if s.server != nil {
@@ -71,18 +84,20 @@ func (s *vsock) Serve(c net.Conn) {
 
 	defer c.Close()
 
-	rc, dialer, err := s.proxy.Dial("tcp", "")
+	sess := proxy.NewSession("vsock", "tcp", c.RemoteAddr(), "")
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", "")
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[vsock] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), s.addr, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		s.proxy.Record(dialer, false)
 		return
 	}
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[vsock] %s <-> %s", c.RemoteAddr(), dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[vsock] %s <-> %s, relay error: %v", c.RemoteAddr(), dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -17,6 +17,8 @@ type vsock struct {
 	server    proxy.Server
 	addr      string
 	cid, port uint32
+
+	listeners proxy.Listeners
 }
 
 // NewVSock returns vm socket proxy.
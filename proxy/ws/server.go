@@ -69,7 +69,10 @@ func NewWSSServer(s string, p proxy.Proxy) (proxy.Server, error) {
 func (s *WS) ListenAndServe() {
 	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
-		log.Fatalf("[ws] failed to listen on %s: %v", s.addr, err)
+		s.listeners.Fail(fmt.Errorf("[ws] failed to listen on %s: %w", s.addr, err))
+		return
+	}
+	if err := s.listeners.Add(l); err != nil {
 		return
 	}
 	defer l.Close()
@@ -79,6 +82,9 @@ func (s *WS) ListenAndServe() {
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if s.listeners.Closed() {
+				return
+			}
 			log.F("[ws] failed to accept: %v", err)
 			continue
 		}
@@ -87,6 +93,12 @@ func (s *WS) ListenAndServe() {
 	}
 }
 
+// Close closes the listeners of the server.
+func (s *WS) Close() error { return s.listeners.Close() }
+
+// Listeners returns the listeners of the server.
+func (s *WS) Listeners() *proxy.Listeners { return &s.listeners }
+
 // Serve serves a connection.
 func (s *WS) Serve(cc net.Conn) {
 	if s.withTLS {
@@ -113,19 +125,21 @@ func (s *WS) Serve(cc net.Conn) {
 
 	defer c.Close()
 
-	rc, dialer, err := s.proxy.Dial("tcp", "")
+	sess := proxy.NewSession("ws", "tcp", c.RemoteAddr(), "")
+	defer sess.Close()
+
+	rc, dialer, err := s.proxy.Dial(&sess.Metadata, "tcp", "")
+	sess.Target = proxy.TunnelTarget(dialer)
 	if err != nil {
-		log.F("[ws] %s <-> %s via %s, error in dial: %v", c.RemoteAddr(), s.addr, dialer.Addr(), err)
+		sess.Dialer, sess.Err = dialer, err
 		s.proxy.Record(dialer, false)
 		return
 	}
 
 	defer rc.Close()
+	sess.Dialer = dialer
 
-	log.F("[ws] %s <-> %s", c.RemoteAddr(), dialer.Addr())
-
-	if err = proxy.Relay(c, rc); err != nil {
-		log.F("[ws] %s <-> %s, relay error: %v", c.RemoteAddr(), dialer.Addr(), err)
+	if err = sess.Relay(c, rc); err != nil {
 		// record remote conn failure only
 		if !strings.Contains(err.Error(), s.addr) {
 			s.proxy.Record(dialer, false)
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/nadoo/glider/dns"
+	"github.com/nadoo/glider/ipset"
+	"github.com/nadoo/glider/pkg/log"
+	"github.com/nadoo/glider/proxy"
+	"github.com/nadoo/glider/rule"
+)
+
+// switchProxy is a proxy which delegates to the current rule proxy,
+// so the rule proxy can be replaced without restarting the servers.
+type switchProxy struct {
+	p atomic.Pointer[rule.Proxy]
+}
+
+func newSwitchProxy(p *rule.Proxy) *switchProxy {
+	s := &switchProxy{}
+	s.p.Store(p)
+	return s
+}
+
+// Dial connects to the given address via the current rule proxy.
+func (s *switchProxy) Dial(meta *proxy.Metadata, network, addr string) (net.Conn, proxy.Dialer, error) {
+	return s.p.Load().Dial(meta, network, addr)
+}
+
+// DialUDP connects to the given address via the current rule proxy.
+func (s *switchProxy) DialUDP(meta *proxy.Metadata, network, addr string) (net.PacketConn, proxy.UDPDialer, error) {
+	return s.p.Load().DialUDP(meta, network, addr)
+}
+
+// NextDialer returns the next dialer of the current rule proxy.
+func (s *switchProxy) NextDialer(meta *proxy.Metadata, network, dstAddr string) proxy.Dialer {
+	return s.p.Load().NextDialer(meta, network, dstAddr)
+}
+
+// Blocked reports whether dstAddr is blocked by the current rule proxy.
+func (s *switchProxy) Blocked(meta *proxy.Metadata, network, dstAddr string) (string, bool) {
+	return s.p.Load().Blocked(meta, network, dstAddr)
+}
+
+// Record records result while using the dialer from proxy.
+func (s *switchProxy) Record(dialer proxy.Dialer, success bool) {
+	s.p.Load().Record(dialer, success)
+}
+
+// AddDomainIP implements the dns AnswerHandler function.
+func (s *switchProxy) AddDomainIP(domain string, ip netip.Addr) error {
+	return s.p.Load().AddDomainIP(domain, ip)
+}
+
+// Groups returns the forwarder groups of the current rule proxy.
+func (s *switchProxy) Groups() []*rule.FwdrGroup {
+	return s.p.Load().Groups()
+}
+
+// Swap stores the new rule proxy and returns the old one.
+func (s *switchProxy) Swap(p *rule.Proxy) *rule.Proxy {
+	return s.p.Swap(p)
+}
+
+// switchIPSet is an ipset manager which can be replaced at runtime.
+type switchIPSet struct {
+	m atomic.Pointer[ipset.Manager]
+}
+
+func newSwitchIPSet(rules []*rule.Config) *switchIPSet {
+	s := &switchIPSet{}
+	s.Reset(rules)
+	return s
+}
+
+// Reset creates a new ipset manager with rules and replaces the current one.
+func (s *switchIPSet) Reset(rules []*rule.Config) {
+	m, _ := ipset.NewManager(rules)
+	s.m.Store(m)
+}
+
+// AddDomainIP implements the dns AnswerHandler function.
+func (s *switchIPSet) AddDomainIP(domain string, ip netip.Addr) error {
+	if m := s.m.Load(); m != nil {
+		return m.AddDomainIP(domain, ip)
+	}
+	return nil
+}
+
+// dnsServers returns the domain specific dns servers in rules.
+func dnsServers(rules []*rule.Config) map[string][]string {
+	servers := make(map[string][]string)
+	for _, r := range rules {
+		if len(r.DNSServers) > 0 {
+			for _, domain := range r.Domain {
+				servers[domain] = r.DNSServers
+			}
+		}
+	}
+	return servers
+}
+
+// listenWait is the max time to wait for the servers to listen.
+const listenWait = time.Second
+
+// reloader reloads the config and rule files.
+type reloader struct {
+	proxy   *switchProxy
+	ipset   *switchIPSet
+	dns     *dns.Server
+	servers map[string]proxy.Server
+
+	accessLog *proxy.AccessLog
+}
+
+// reload parses the config and rule files again, replaces the rule proxy
+// and starts or stops the changed listeners. Connections being served keep
+// using their old dialers. Changes of other settings need a restart.
+func (r *reloader) reload() {
+	log.Print("[reload] reloading config and rule files")
+
+	// reopen the access log file so it can be rotated.
+	if r.accessLog != nil {
+		if err := r.accessLog.Reopen(); err != nil {
+			log.Printf("[reload] failed to reopen access log: %v", err)
+		}
+	}
+
+	conf, err := reloadConfig()
+	if err != nil {
+		log.Printf("[reload] failed to parse config: %v, keep the running config", err)
+		return
+	}
+
+	p, err := rule.NewProxy(conf.Forwards, conf.ForwardSubs, &conf.Strategy, conf.rules)
+	if err != nil {
+		log.Printf("[reload] failed to create rule proxy: %v, keep the running config", err)
+		return
+	}
+
+	// create the new servers first so that we can still give up on errors.
+	added := make(map[string]proxy.Server)
+	for _, listen := range conf.Listens {
+		if _, ok := r.servers[listen]; ok {
+			continue
+		}
+		local, err := proxy.ServerFromURL(listen, proxy.WithListener(r.proxy, listen))
+		if err != nil {
+			log.Printf("[reload] failed to create server %s: %v, keep the running config", listen, err)
+			p.Close()
+			return
+		}
+		added[listen] = local
+	}
+
+	// stop the removed servers first as the added ones may listen on the same
+	// addresses, they are started again if any added server fails to listen.
+	var removed []string
+	for listen, local := range r.servers {
+		if !slices.Contains(conf.Listens, listen) {
+			local.Close()
+			removed = append(removed, listen)
+		}
+	}
+
+	for _, local := range added {
+		go local.ListenAndServe()
+	}
+
+	if err := proxy.WaitListen(slices.Collect(maps.Values(added)), listenWait); err != nil {
+		log.Printf("[reload] failed to start servers: %v, keep the running config", err)
+		for _, local := range added {
+			local.Close()
+		}
+		for _, listen := range removed {
+			local, err := proxy.ServerFromURL(listen, proxy.WithListener(r.proxy, listen))
+			if err != nil {
+				log.Printf("[reload] failed to restore server %s: %v", listen, err)
+				delete(r.servers, listen)
+				continue
+			}
+			r.servers[listen] = local
+			go local.ListenAndServe()
+		}
+		p.Close()
+		return
+	}
+
+	for _, listen := range removed {
+		delete(r.servers, listen)
+		log.Printf("[reload] stopped server %s", listen)
+	}
+
+	for listen, local := range added {
+		r.servers[listen] = local
+		log.Printf("[reload] started server %s", listen)
+	}
+
+	r.ipset.Reset(conf.rules)
+	if r.dns != nil {
+		r.dns.ResetServers(dnsServers(conf.rules))
+		r.dns.SetBlocklists(conf.DNSConfig.BlockLists, conf.DNSConfig.AllowLists)
+		r.dns.SetRecords(conf.DNSConfig.Records, conf.DNSConfig.Hosts)
+	}
+
+	proxy.SetBlockPage(conf.blockPage)
+	r.proxy.Swap(p).Close()
+
+	// rebuild the domain based ip rules with the cached dns answers.
+	if r.dns != nil {
+		r.dns.ReplayAnswers()
+	}
+
+	for _, c := range conf.rules {
+		c.IP, c.CIDR, c.Domain = nil, nil, nil
+		c.DomainFull, c.DomainKeyword, c.DomainRegex = nil, nil, nil
+	}
+
+	p.Check()
+
+	config = conf
+	log.Printf("[reload] reloaded with %d forwarders, %d rule files and %d listeners",
+		len(conf.Forwards), len(conf.rules), len(r.servers))
+}
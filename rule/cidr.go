@@ -0,0 +1,81 @@
+package rule
+
+import "net/netip"
+
+// cidrTrie is a binary radix trie of cidrs for longest prefix matching,
+// it should be read-only after inserting all the cidrs.
+type cidrTrie[T any] struct {
+	v4, v6 *cidrNode[T]
+	size   int
+}
+
+type cidrNode[T any] struct {
+	child [2]*cidrNode[T]
+	v     T
+	ok    bool
+}
+
+// insert inserts the cidr with value v, the old value of the same cidr will be replaced.
+func (t *cidrTrie[T]) insert(cidr netip.Prefix, v T) {
+	addr, bits := cidr.Addr(), cidr.Bits()
+	if addr.Is4In6() && bits >= 96 {
+		addr, bits = addr.Unmap(), bits-96
+	}
+
+	root := &t.v6
+	if addr.Is4() {
+		root = &t.v4
+	}
+	if *root == nil {
+		*root = &cidrNode[T]{}
+	}
+
+	b := addr.As16()
+	n := *root
+	for i := range bits {
+		bit := bitAt(b, addr.Is4(), i)
+		if n.child[bit] == nil {
+			n.child[bit] = &cidrNode[T]{}
+		}
+		n = n.child[bit]
+	}
+
+	if !n.ok {
+		t.size++
+	}
+	n.v, n.ok = v, true
+}
+
+// lookup returns the value of the longest cidr contains ip.
+func (t *cidrTrie[T]) lookup(ip netip.Addr) (v T, ok bool) {
+	ip = ip.Unmap()
+
+	n, bits := t.v6, 128
+	if ip.Is4() {
+		n, bits = t.v4, 32
+	}
+
+	b := ip.As16()
+	for i := 0; n != nil; i++ {
+		if n.ok {
+			v, ok = n.v, true
+		}
+		if i == bits {
+			break
+		}
+		n = n.child[bitAt(b, ip.Is4(), i)]
+	}
+
+	return
+}
+
+// len returns the number of cidrs in the trie.
+func (t *cidrTrie[T]) len() int { return t.size }
+
+// bitAt returns the i-th bit of the ip address b, counted from the ipv4 part if is4.
+func bitAt(b [16]byte, is4 bool, i int) int {
+	if is4 {
+		i += 96
+	}
+	return int(b[i/8]>>(7-i%8)) & 1
+}
@@ -0,0 +1,117 @@
+package rule
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+func TestCIDRTrieLongestPrefix(t *testing.T) {
+	var trie cidrTrie[string]
+	for _, cidr := range []string{
+		"0.0.0.0/0",
+		"10.0.0.0/8",
+		"10.1.0.0/16",
+		"10.1.2.0/24",
+		"10.1.2.3/32",
+		"192.168.0.0/16",
+		"::/0",
+		"2001:db8::/32",
+		"2001:db8:1::/48",
+		"2001:db8:1:2::/64",
+		"::ffff:172.16.0.0/108", // ipv4-mapped, stored as 172.16.0.0/12
+	} {
+		trie.insert(netip.MustParsePrefix(cidr), cidr)
+	}
+
+	if n := trie.len(); n != 11 {
+		t.Fatalf("len() = %d, want 11", n)
+	}
+
+	for _, tt := range []struct {
+		ip, want string
+	}{
+		{"10.1.2.3", "10.1.2.3/32"},
+		{"10.1.2.4", "10.1.2.0/24"},
+		{"10.1.3.1", "10.1.0.0/16"},
+		{"10.2.0.1", "10.0.0.0/8"},
+		{"192.168.1.1", "192.168.0.0/16"},
+		{"8.8.8.8", "0.0.0.0/0"},
+		{"172.16.1.1", "::ffff:172.16.0.0/108"},
+		{"::ffff:10.1.2.3", "10.1.2.3/32"},
+		{"2001:db8:1:2::1", "2001:db8:1:2::/64"},
+		{"2001:db8:1:3::1", "2001:db8:1::/48"},
+		{"2001:db8:2::1", "2001:db8::/32"},
+		{"2400:cb00::1", "::/0"},
+	} {
+		got, ok := trie.lookup(netip.MustParseAddr(tt.ip))
+		if !ok || got != tt.want {
+			t.Errorf("lookup(%s) = %q, %v, want %q", tt.ip, got, ok, tt.want)
+		}
+	}
+
+	// no default routes, the lookup must not fall back to the other family.
+	var trie2 cidrTrie[string]
+	trie2.insert(netip.MustParsePrefix("10.0.0.0/8"), "v4")
+	trie2.insert(netip.MustParsePrefix("2001:db8::/32"), "v6")
+	for _, ip := range []string{"11.0.0.1", "2001:db9::1", "::a00:1"} {
+		if v, ok := trie2.lookup(netip.MustParseAddr(ip)); ok {
+			t.Errorf("lookup(%s) = %q, want no match", ip, v)
+		}
+	}
+}
+
+// randomPrefixes returns n random ipv4 and ipv6 prefixes.
+func randomPrefixes(r *rand.Rand, n int) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, n)
+	for i := range n {
+		var b [16]byte
+		for j := range b {
+			b[j] = byte(r.IntN(256))
+		}
+		if i%4 == 0 {
+			prefixes = append(prefixes, netip.PrefixFrom(netip.AddrFrom16(b), 32+r.IntN(33)).Masked())
+		} else {
+			prefixes = append(prefixes, netip.PrefixFrom(netip.AddrFrom4([4]byte(b[:4])), 8+r.IntN(17)).Masked())
+		}
+	}
+	return prefixes
+}
+
+func BenchmarkCIDR(b *testing.B) {
+	r := rand.New(rand.NewPCG(1, 2))
+	prefixes := randomPrefixes(r, 8192)
+
+	var trie cidrTrie[string]
+	var m sync.Map
+	for _, p := range prefixes {
+		trie.insert(p, "forward")
+		m.Store(p, "forward")
+	}
+
+	ips := make([]netip.Addr, 1024)
+	for i := range ips {
+		// half of the ips are in the prefixes.
+		if p := prefixes[r.IntN(len(prefixes))]; i%2 == 0 {
+			ips[i] = p.Addr()
+		} else {
+			ips[i] = netip.AddrFrom4([4]byte{byte(r.IntN(256)), byte(r.IntN(256)), byte(r.IntN(256)), byte(r.IntN(256))})
+		}
+	}
+
+	b.Run("trie", func(b *testing.B) {
+		for i := 0; b.Loop(); i++ {
+			trie.lookup(ips[i%len(ips)])
+		}
+	})
+
+	b.Run("range", func(b *testing.B) {
+		for i := 0; b.Loop(); i++ {
+			ip := ips[i%len(ips)]
+			m.Range(func(k, v any) bool {
+				return !k.(netip.Prefix).Contains(ip)
+			})
+		}
+	})
+}
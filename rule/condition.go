@@ -0,0 +1,92 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// portRange is a range of ports, both ends included.
+type portRange struct {
+	from, to uint16
+}
+
+// condition is the port and network condition of a rule file, it's combined
+// with the other matchers in the same rule file with AND.
+type condition struct {
+	ports    []portRange
+	networks []string
+}
+
+// newCondition parses the `port`, `portrange` and `network` values of a rule file.
+func newCondition(ports, portRanges, networks []string) (*condition, error) {
+	c := &condition{}
+	for _, s := range ports {
+		port, err := strconv.ParseUint(s, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid port: %s", s)
+		}
+		c.ports = append(c.ports, portRange{uint16(port), uint16(port)})
+	}
+
+	for _, s := range portRanges {
+		from, to, ok := strings.Cut(s, "-")
+		start, err1 := strconv.ParseUint(from, 10, 16)
+		end, err2 := strconv.ParseUint(to, 10, 16)
+		if !ok || err1 != nil || err2 != nil || start == 0 || start > end {
+			return nil, fmt.Errorf("invalid port range: %s, format: FROM-TO", s)
+		}
+		c.ports = append(c.ports, portRange{uint16(start), uint16(end)})
+	}
+
+	for _, s := range networks {
+		s = strings.ToLower(s)
+		if s != "tcp" && s != "udp" {
+			return nil, fmt.Errorf("invalid network: %s, should be tcp or udp", s)
+		}
+		c.networks = append(c.networks, s)
+	}
+
+	return c, nil
+}
+
+// empty reports whether there's no condition.
+func (c *condition) empty() bool {
+	return c == nil || (len(c.ports) == 0 && len(c.networks) == 0)
+}
+
+// match reports whether network and port match the condition,
+// unknown network(empty) or port(0) is treated as matched when known is false.
+func (c *condition) match(network string, port uint16, known bool) bool {
+	if c.empty() {
+		return true
+	}
+
+	if len(c.networks) > 0 && (network != "" || known) {
+		matched := false
+		for _, n := range c.networks {
+			if strings.HasPrefix(network, n) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(c.ports) > 0 && (port != 0 || known) {
+		matched := false
+		for _, r := range c.ports {
+			if port >= r.from && port <= r.to {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
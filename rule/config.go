@@ -1,25 +1,49 @@
 package rule
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
 	"github.com/nadoo/conflag"
+
+	"github.com/nadoo/glider/pkg/geo"
 )
 
 // Config is config of rule.
 type Config struct {
 	RulePath string
 
-	Forward  []string
-	Strategy Strategy
+	Forward    []string
+	ForwardSub []string
+	Strategy   Strategy
 
 	DNSServers []string
 	IPSet      string
 
-	Domain []string
-	IP     []string
-	CIDR   []string
+	User     []string
+	Listener []string
+	SrcIP    []string
+	SrcCIDR  []string
+
+	Domain        []string
+	DomainFull    []string
+	DomainKeyword []string
+	DomainRegex   []string
+	IP            []string
+	CIDR          []string
+
+	GeoIP   []string
+	GeoSite []string
+	RuleSet []string
+
+	Port      []string
+	PortRange []string
+	Network   []string
+
+	cond     *condition
+	geoIP    *geo.IPDB
+	ruleSets []*RuleSet
 }
 
 // Strategy configurations.
@@ -43,6 +67,7 @@ func NewConfFromFile(ruleFile string) (*Config, error) {
 
 	f := conflag.NewFromFile("rule", ruleFile)
 	f.StringSliceUniqVar(&p.Forward, "forward", nil, "forward url, format: SCHEME://[USER|METHOD:PASSWORD@][HOST]:PORT?PARAMS[,SCHEME://[USER|METHOD:PASSWORD@][HOST]:PORT?PARAMS]")
+	f.StringSliceUniqVar(&p.ForwardSub, "forwardsub", nil, "forwarder subscription url, format: http[s]://HOST/PATH[#interval=SECONDS]")
 	f.StringVar(&p.Strategy.Strategy, "strategy", "rr", "forward strategy, default: rr")
 	f.StringVar(&p.Strategy.Check, "check", "http://www.msftconnecttest.com/connecttest.txt#expect=200", "check=tcp[://HOST:PORT]: tcp port connect check\ncheck=http://HOST[:PORT][/URI][#expect=STRING_IN_RESP_LINE]\ncheck=file://SCRIPT_PATH: run a check script, healthy when exitcode=0, environment variables: FORWARDER_ADDR\ncheck=disable: disable health check")
 	f.IntVar(&p.Strategy.CheckInterval, "checkinterval", 30, "fowarder check interval(seconds)")
@@ -55,19 +80,40 @@ func NewConfFromFile(ruleFile string) (*Config, error) {
 	f.IntVar(&p.Strategy.RelayTimeout, "relaytimeout", 0, "relay timeout(seconds)")
 	f.StringVar(&p.Strategy.IntFace, "interface", "", "source ip or source interface")
 
-	f.StringSliceUniqVar(&p.DNSServers, "dnsserver", nil, "remote dns server")
+	f.StringSliceUniqVar(&p.DNSServers, "dnsserver", nil, "remote dns server, HOST[:PORT] or https://HOST[:PORT]/PATH for DNS-over-HTTPS, tls://HOST[:PORT] for DNS-over-TLS")
 	f.StringVar(&p.IPSet, "ipset", "", "ipset NAME, will create 2 sets: NAME for ipv4 and NAME6 for ipv6")
 
-	f.StringSliceVar(&p.Domain, "domain", nil, "domain")
+	f.StringSliceVar(&p.User, "user", nil, "authenticated user name")
+	f.StringSliceVar(&p.Listener, "listener", nil, "listen url, same as the value of -listen")
+	f.StringSliceVar(&p.SrcIP, "srcip", nil, "source ip")
+	f.StringSliceVar(&p.SrcCIDR, "srccidr", nil, "source cidr")
+
+	f.StringSliceVar(&p.Domain, "domain", nil, "domain, wildcards supported: *.example.com")
+	f.StringSliceVar(&p.DomainFull, "domain-full", nil, "full domain, subdomains are not matched")
+	f.StringSliceVar(&p.DomainKeyword, "domain-keyword", nil, "domain keyword")
+	f.StringSliceVar(&p.DomainRegex, "domain-regex", nil, "domain regular expression")
 	f.StringSliceVar(&p.IP, "ip", nil, "ip")
 	f.StringSliceVar(&p.CIDR, "cidr", nil, "cidr")
 
+	f.StringSliceVar(&p.GeoIP, "geoip", nil, "country code in the geoip database, e.g. cn")
+	f.StringSliceVar(&p.GeoSite, "geosite", nil, "domain list name in the geosite file, format: NAME[@ATTR], e.g. google")
+	f.StringSliceVar(&p.RuleSet, "ruleset", nil, "remote rule list url, format: http[s]://HOST/PATH[#interval=SECONDS&via=GROUP]")
+
+	f.StringSliceVar(&p.Port, "port", nil, "destination port")
+	f.StringSliceVar(&p.PortRange, "portrange", nil, "destination port range, format: FROM-TO")
+	f.StringSliceVar(&p.Network, "network", nil, "network: tcp or udp")
+
 	err := f.Parse()
 	if err != nil {
 		return nil, err
 	}
 
-	return p, err
+	p.cond, err = newCondition(p.Port, p.PortRange, p.Network)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ruleFile, err)
+	}
+
+	return p, nil
 }
 
 // ListDir returns file list named with suffix in dirPth.
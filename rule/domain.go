@@ -0,0 +1,251 @@
+package rule
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DomainMatcher matches domains with domain, wildcard, keyword and regexp rules,
+// rules should be added before calling Compile, and it's read-only after that.
+//
+// the rules are checked in order: full, domain and subdomains, wildcard, keyword, regexp.
+type DomainMatcher[T any] struct {
+	fulls      map[string]T // matches the domain only
+	domains    map[string]T // matches the domain and its subdomains
+	subdomains map[string]T // *.domain, matches subdomains only
+	wildcards  []domainRegexp[T]
+	wildIndex  map[string][]int // literal domain suffix -> indexes of the wildcards end with it
+	wildOthers []int            // indexes of the wildcards without a literal domain suffix
+	keywords   keywordMatcher[T]
+	regexps    []domainRegexp[T]
+}
+
+type domainRegexp[T any] struct {
+	re *regexp.Regexp
+	v  T
+}
+
+// NewDomainMatcher returns a new domain matcher.
+func NewDomainMatcher[T any]() *DomainMatcher[T] {
+	m := &DomainMatcher[T]{
+		fulls:      make(map[string]T),
+		domains:    make(map[string]T),
+		subdomains: make(map[string]T),
+		wildIndex:  make(map[string][]int),
+	}
+	m.keywords.init()
+	return m
+}
+
+// AddDomain adds a domain rule matches the domain and its subdomains,
+// wildcards are supported: `*` matches any characters and `?` matches one character,
+// e.g. `*.example.com` matches subdomains of example.com only, `cdn?.example.*`.
+func (m *DomainMatcher[T]) AddDomain(domain string, v T) error {
+	domain = strings.ToLower(domain)
+	if !strings.ContainsAny(domain, "*?") {
+		m.domains[domain] = v
+		return nil
+	}
+
+	if suffix, ok := strings.CutPrefix(domain, "*."); ok && !strings.ContainsAny(suffix, "*?") {
+		m.subdomains[suffix] = v
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('^')
+	for _, c := range domain {
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteByte('.')
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteByte('$')
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return err
+	}
+
+	// index the wildcard by the whole labels after its last wildcard character,
+	// so it's only checked for the domains end with them.
+	tail := domain[strings.LastIndexAny(domain, "*?")+1:]
+	if _, suffix, ok := strings.Cut(tail, "."); ok && suffix != "" {
+		m.wildIndex[suffix] = append(m.wildIndex[suffix], len(m.wildcards))
+	} else {
+		m.wildOthers = append(m.wildOthers, len(m.wildcards))
+	}
+	m.wildcards = append(m.wildcards, domainRegexp[T]{re, v})
+
+	return nil
+}
+
+// AddFull adds a domain rule matches the domain only.
+func (m *DomainMatcher[T]) AddFull(domain string, v T) {
+	m.fulls[strings.ToLower(domain)] = v
+}
+
+// AddKeyword adds a keyword rule matches domains contain the keyword.
+func (m *DomainMatcher[T]) AddKeyword(keyword string, v T) {
+	if keyword != "" {
+		m.keywords.add(strings.ToLower(keyword), v)
+	}
+}
+
+// AddRegexp adds a regexp rule matches domains with the regular expression.
+func (m *DomainMatcher[T]) AddRegexp(expr string, v T) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	m.regexps = append(m.regexps, domainRegexp[T]{re, v})
+	return nil
+}
+
+// Compile prepares the matcher for lookups, it must be called after adding rules.
+func (m *DomainMatcher[T]) Compile() { m.keywords.build() }
+
+// Match returns the value of the first rule matches domain.
+func (m *DomainMatcher[T]) Match(domain string) (v T, ok bool) {
+	m.Range(domain, func(val T) bool {
+		v, ok = val, true
+		return false
+	})
+	return
+}
+
+// Range calls f with the values of rules match domain in order, it stops if f returns false.
+func (m *DomainMatcher[T]) Range(domain string, f func(v T) bool) {
+	domain = strings.ToLower(domain)
+
+	if v, ok := m.fulls[domain]; ok && !f(v) {
+		return
+	}
+
+	for i := len(domain); i != -1; {
+		i = strings.LastIndexByte(domain[:i], '.')
+		suffix := domain[i+1:]
+		if v, ok := m.domains[suffix]; ok && !f(v) {
+			return
+		}
+		if i == -1 {
+			break
+		}
+		if v, ok := m.subdomains[suffix]; ok && !f(v) {
+			return
+		}
+	}
+
+	if len(m.wildcards) > 0 {
+		// the candidates are checked in the order they were added.
+		candidates := slices.Clone(m.wildOthers)
+		for i := len(domain); i != -1; {
+			i = strings.LastIndexByte(domain[:i], '.')
+			candidates = append(candidates, m.wildIndex[domain[i+1:]]...)
+		}
+		slices.Sort(candidates)
+
+		for _, i := range candidates {
+			if w := m.wildcards[i]; w.re.MatchString(domain) && !f(w.v) {
+				return
+			}
+		}
+	}
+
+	if !m.keywords.match(domain, f) {
+		return
+	}
+
+	for _, r := range m.regexps {
+		if r.re.MatchString(domain) && !f(r.v) {
+			return
+		}
+	}
+}
+
+// keywordMatcher is an Aho-Corasick automaton matches all the keywords in one pass.
+type keywordMatcher[T any] struct {
+	nodes  []keywordNode
+	values []T
+}
+
+type keywordNode struct {
+	next map[byte]int
+	fail int
+	out  []int // indexes of the keywords end at this node
+}
+
+func (k *keywordMatcher[T]) init() {
+	k.nodes = []keywordNode{{next: make(map[byte]int)}}
+}
+
+func (k *keywordMatcher[T]) add(keyword string, v T) {
+	n := 0
+	for i := 0; i < len(keyword); i++ {
+		next, ok := k.nodes[n].next[keyword[i]]
+		if !ok {
+			next = len(k.nodes)
+			k.nodes = append(k.nodes, keywordNode{next: make(map[byte]int)})
+			k.nodes[n].next[keyword[i]] = next
+		}
+		n = next
+	}
+	k.nodes[n].out = append(k.nodes[n].out, len(k.values))
+	k.values = append(k.values, v)
+}
+
+// build sets the failure links in breadth-first order.
+func (k *keywordMatcher[T]) build() {
+	queue := []int{0}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		for c, next := range k.nodes[n].next {
+			queue = append(queue, next)
+			if n == 0 {
+				k.nodes[next].fail = 0
+				continue
+			}
+
+			f := k.nodes[n].fail
+			for f != 0 && k.nodes[f].next[c] == 0 {
+				f = k.nodes[f].fail
+			}
+			k.nodes[next].fail = k.nodes[f].next[c]
+			k.nodes[next].out = append(k.nodes[next].out, k.nodes[k.nodes[next].fail].out...)
+		}
+	}
+}
+
+// match calls f with the values of keywords in s in the order they were added,
+// it returns false if f returns false.
+func (k *keywordMatcher[T]) match(s string, f func(v T) bool) bool {
+	if len(k.values) == 0 {
+		return true
+	}
+
+	var found []int
+	n := 0
+	for i := 0; i < len(s); i++ {
+		for n != 0 && k.nodes[n].next[s[i]] == 0 {
+			n = k.nodes[n].fail
+		}
+		n = k.nodes[n].next[s[i]]
+		found = append(found, k.nodes[n].out...)
+	}
+
+	slices.Sort(found)
+	for _, i := range slices.Compact(found) {
+		if !f(k.values[i]) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,52 @@
+package rule
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDomainMatcherWildcard(t *testing.T) {
+	m := NewDomainMatcher[string]()
+	for _, rule := range []string{
+		"cdn?.example.*",
+		"*.cdn-?.example.com",
+		"a*x.example.com",
+		"*foo.org",
+		"img*.example.com",
+	} {
+		if err := m.AddDomain(rule, rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Compile()
+
+	for _, tt := range []struct {
+		domain, want string
+	}{
+		{"cdn1.example.net", "cdn?.example.*"},
+		{"cdn1.example.com", "cdn?.example.*"}, // added first
+		{"www.cdn-1.example.com", "*.cdn-?.example.com"},
+		{"abcx.example.com", "a*x.example.com"},
+		{"barfoo.org", "*foo.org"},
+		{"IMG2.Example.com", "img*.example.com"},
+		{"cdn-1.example.com", ""},
+		{"abc.example.com", ""},
+		{"foo.org.cn", ""},
+	} {
+		if got, _ := m.Match(tt.domain); got != tt.want {
+			t.Errorf("Match(%s) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkDomainMatcherWildcard(b *testing.B) {
+	m := NewDomainMatcher[int]()
+	for i := range 20000 {
+		m.AddDomain(fmt.Sprintf("cdn?-%d.example%d.com", i, i), i)
+	}
+	m.Compile()
+
+	for b.Loop() {
+		m.Match("cdn1-19999.example19999.com")
+	}
+}
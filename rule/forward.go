@@ -1,6 +1,7 @@
 package rule
 
 import (
+	"errors"
 	"net"
 	"net/url"
 	"strconv"
@@ -26,6 +27,7 @@ type Forwarder struct {
 	failures    uint32
 	latency     int64
 	intface     string // local interface or ip address
+	group       string // name of the group which the forwarder belongs to
 	handlers    []StatusHandler
 }
 
@@ -113,15 +115,30 @@ func (f *Forwarder) URL() string {
 	return f.url
 }
 
+// Group returns the name of the group which the forwarder belongs to.
+func (f *Forwarder) Group() string {
+	return f.group
+}
+
 // Dial dials to addr and returns conn.
 func (f *Forwarder) Dial(network, addr string) (c net.Conn, err error) {
 	c, err = f.Dialer.Dial(network, addr)
-	if err != nil {
+	if err != nil && !errors.Is(err, proxy.ErrBlocked) {
+		dialErrors.With(f.addr).Inc()
 		f.IncFailures()
 	}
 	return c, err
 }
 
+// DialUDP connects to the given address.
+func (f *Forwarder) DialUDP(network, addr string) (pc net.PacketConn, err error) {
+	pc, err = f.Dialer.DialUDP(network, addr)
+	if err != nil && !errors.Is(err, proxy.ErrBlocked) {
+		dialErrors.With(f.addr).Inc()
+	}
+	return pc, err
+}
+
 // Failures returns the failuer count of forwarder.
 func (f *Forwarder) Failures() uint32 {
 	return atomic.LoadUint32(&f.failures)
@@ -137,7 +154,8 @@ func (f *Forwarder) IncFailures() {
 	// log.F("[forwarder] %s(%d) recorded %d failures, maxfailures: %d", f.addr, f.Priority(), failures, f.MaxFailures())
 
 	if failures == f.MaxFailures() && f.Enabled() {
-		log.F("[forwarder] %s(%d) reaches maxfailures: %d", f.addr, f.Priority(), f.MaxFailures())
+		log.Warn("[forwarder] reaches maxfailures", "forwarder", f.addr, "rule_group", f.group,
+			"priority", f.Priority(), "maxfailures", f.MaxFailures())
 		f.Disable()
 	}
 }
@@ -182,7 +200,11 @@ func (f *Forwarder) Priority() uint32 {
 
 // SetPriority sets the priority of forwarder.
 func (f *Forwarder) SetPriority(l uint32) {
-	atomic.StoreUint32(&f.priority, l)
+	if atomic.SwapUint32(&f.priority, l) != l {
+		for _, h := range f.handlers {
+			h(f)
+		}
+	}
 }
 
 // MaxFailures returns the maxFailures of forwarder.
@@ -0,0 +1,77 @@
+package rule
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/nadoo/glider/pkg/geo"
+)
+
+// LoadGeoData opens the geoip database for the `geoip` rules and expands the
+// `geosite` rules to domain rules with the geosite file.
+func LoadGeoData(rules []*Config, geoIPFile, geoSiteFile string) error {
+	var db *geo.IPDB
+	var codes []string
+	for _, r := range rules {
+		if len(r.GeoIP) > 0 && db == nil {
+			if geoIPFile == "" {
+				return errors.New(r.RulePath + ": geoip rules need the geoip database, please set -geoipfile")
+			}
+
+			var err error
+			if db, err = geo.OpenIPDB(geoIPFile); err != nil {
+				return err
+			}
+		}
+		r.geoIP = db
+
+		for i, code := range r.GeoIP {
+			r.GeoIP[i] = strings.ToUpper(code)
+		}
+
+		codes = append(codes, r.GeoSite...)
+	}
+
+	if len(codes) == 0 {
+		return nil
+	}
+
+	if geoSiteFile == "" {
+		return errors.New("geosite rules need the geosite file, please set -geositefile")
+	}
+
+	sites, err := geo.LoadSites(geoSiteFile, codes)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		for _, code := range r.GeoSite {
+			for _, d := range sites[strings.ToLower(code)] {
+				switch d.Type {
+				case geo.DomainKeyword:
+					r.DomainKeyword = append(r.DomainKeyword, d.Value)
+				case geo.DomainRegex:
+					// the domains are matched in lower case.
+					r.DomainRegex = append(r.DomainRegex, "(?i)"+d.Value)
+				case geo.DomainSuffix:
+					r.Domain = append(r.Domain, d.Value)
+				case geo.DomainFull:
+					r.DomainFull = append(r.DomainFull, d.Value)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// MatchGeoIP reports whether ip belongs to the countries of the `geoip` rules.
+func (c *Config) MatchGeoIP(ip netip.Addr) bool {
+	if c.geoIP == nil || len(c.GeoIP) == 0 {
+		return false
+	}
+	return slices.Contains(c.GeoIP, c.geoIP.Country(ip))
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"hash/fnv"
 	"net"
+	"net/http"
 	"net/url"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -33,39 +35,89 @@ type FwdrGroup struct {
 	index    uint32
 	priority uint32
 	next     func(addr string) *Forwarder
+	checker  Checker
+	done     chan struct{}
+
+	// forwarder subscriptions
+	static    []*Forwarder // forwarders not from subscriptions
+	reject    *Forwarder   // used when there's no forwarder
+	subs      []*subscription
+	subClient *http.Client
+	subHosts  map[string]struct{} // hostnames of the subscribed forwarders
 }
 
-// NewFwdrGroup returns a new forward group.
-func NewFwdrGroup(rulePath string, s []string, c *Strategy) *FwdrGroup {
+// NewFwdrGroup returns a new forward group, the forwarders in subscriptions subs are fetched
+// before returning and refreshed after Check called.
+func NewFwdrGroup(rulePath string, s, subs []string, c *Strategy) (*FwdrGroup, error) {
 	var fwdrs []*Forwarder
 	for _, chain := range s {
 		fwdr, err := ForwarderFromURL(chain, c.IntFace,
 			time.Duration(c.DialTimeout)*time.Second, time.Duration(c.RelayTimeout)*time.Second)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		fwdr.SetMaxFailures(uint32(c.MaxFailures))
 		fwdrs = append(fwdrs, fwdr)
 	}
 
+	var subscriptions []*subscription
+	for _, s := range subs {
+		sub, err := newSubscription(s)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(rulePath), filepath.Ext(rulePath))
+	if len(subscriptions) > 0 {
+		return newSubFwdrGroup(name, fwdrs, subscriptions, c)
+	}
+
 	if len(fwdrs) == 0 {
 		// direct forwarder
 		direct, err := DirectForwarder(c.IntFace,
 			time.Duration(c.DialTimeout)*time.Second, time.Duration(c.RelayTimeout)*time.Second)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		fwdrs = append(fwdrs, direct)
 		c.Strategy = "rr"
 	}
 
-	name := strings.TrimSuffix(filepath.Base(rulePath), filepath.Ext(rulePath))
-	return newFwdrGroup(name, fwdrs, c)
+	return newFwdrGroup(name, fwdrs, c), nil
+}
+
+// newSubFwdrGroup returns a new FwdrGroup with forwarder subscriptions.
+func newSubFwdrGroup(name string, fwdrs []*Forwarder, subs []*subscription, c *Strategy) (*FwdrGroup, error) {
+	reject, err := ForwarderFromURL("reject://", c.IntFace, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	reject.group = name
+
+	p := newFwdrGroup(name, slices.Clone(fwdrs), c)
+	p.static, p.reject, p.subs = fwdrs, reject, subs
+	if p.subClient, err = p.newSubClient(); err != nil {
+		return nil, err
+	}
+
+	p.refreshSubs()
+
+	p.mu.Lock()
+	p.rebuild()
+	p.mu.Unlock()
+
+	// forwarders may be added later, so the strategy is always used.
+	p.setScheduler(len(p.fwdrs))
+
+	return p, nil
 }
 
 // newFwdrGroup returns a new FwdrGroup.
 func newFwdrGroup(name string, fwdrs []*Forwarder, c *Strategy) *FwdrGroup {
-	p := &FwdrGroup{name: name, fwdrs: fwdrs, config: c}
+	p := &FwdrGroup{name: name, fwdrs: fwdrs, static: fwdrs, config: c, done: make(chan struct{})}
 	sort.Sort(p.fwdrs)
 
 	p.init()
@@ -75,32 +127,39 @@ func newFwdrGroup(name string, fwdrs []*Forwarder, c *Strategy) *FwdrGroup {
 
 	// if there're more than 1 forwarders, we care about the strategy.
 	if count := len(fwdrs); count > 1 {
-		switch c.Strategy {
-		case "rr":
-			p.next = p.scheduleRR
-			log.F("[strategy] %s: %d forwarders forward in round robin mode.", name, count)
-		case "ha":
-			p.next = p.scheduleHA
-			log.F("[strategy] %s: %d forwarders forward in high availability mode.", name, count)
-		case "lha":
-			p.next = p.scheduleLHA
-			log.F("[strategy] %s: %d forwarders forward in latency based high availability mode.", name, count)
-		case "dh":
-			p.next = p.scheduleDH
-			log.F("[strategy] %s: %d forwarders forward in destination hashing mode.", name, count)
-		default:
-			p.next = p.scheduleRR
-			log.F("[strategy] %s: not supported forward mode '%s', use round robin mode for %d forwarders.", name, c.Strategy, count)
-		}
+		p.setScheduler(count)
 	}
 
 	for _, f := range fwdrs {
+		f.group = name
 		f.AddHandler(p.onStatusChanged)
+		setEnabledMetric(name, f)
 	}
 
 	return p
 }
 
+// setScheduler sets the scheduler of the group by strategy.
+func (p *FwdrGroup) setScheduler(count int) {
+	switch p.config.Strategy {
+	case "rr":
+		p.next = p.scheduleRR
+		log.F("[strategy] %s: %d forwarders forward in round robin mode.", p.name, count)
+	case "ha":
+		p.next = p.scheduleHA
+		log.F("[strategy] %s: %d forwarders forward in high availability mode.", p.name, count)
+	case "lha":
+		p.next = p.scheduleLHA
+		log.F("[strategy] %s: %d forwarders forward in latency based high availability mode.", p.name, count)
+	case "dh":
+		p.next = p.scheduleDH
+		log.F("[strategy] %s: %d forwarders forward in destination hashing mode.", p.name, count)
+	default:
+		p.next = p.scheduleRR
+		log.F("[strategy] %s: not supported forward mode '%s', use round robin mode for %d forwarders.", p.name, p.config.Strategy, count)
+	}
+}
+
 // Dial connects to the address addr on the network net.
 func (p *FwdrGroup) Dial(network, addr string) (net.Conn, proxy.Dialer, error) {
 	nd := p.NextDialer(addr)
@@ -127,6 +186,43 @@ func (p *FwdrGroup) NextDialer(dstAddr string) proxy.Dialer {
 	return p.next(dstAddr)
 }
 
+// blocks reports whether all the forwarders of the group block connections.
+func (p *FwdrGroup) blocks() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, f := range p.fwdrs {
+		if f.Addr() != "BLOCK" {
+			return false
+		}
+	}
+	return len(p.fwdrs) > 0
+}
+
+// Name returns the name of the group.
+func (p *FwdrGroup) Name() string { return p.name }
+
+// Strategy returns the forward strategy of the group.
+func (p *FwdrGroup) Strategy() string { return p.config.Strategy }
+
+// Forwarders returns all the forwarders in the group, ordered by priority.
+func (p *FwdrGroup) Forwarders() []*Forwarder {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.Clone(p.fwdrs)
+}
+
+// Forwarder returns the forwarder with the given addr in the group.
+func (p *FwdrGroup) Forwarder(addr string) *Forwarder {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, f := range p.fwdrs {
+		if f.Addr() == addr {
+			return f
+		}
+	}
+	return nil
+}
+
 // Priority returns the active priority of dialer.
 func (p *FwdrGroup) Priority() uint32 { return atomic.LoadUint32(&p.priority) }
 
@@ -156,19 +252,30 @@ func (p *FwdrGroup) init() {
 	}
 }
 
-// onStatusChanged will be called when fwdr's status changed.
+// onStatusChanged will be called when fwdr's status or priority changed.
 func (p *FwdrGroup) onStatusChanged(fwdr *Forwarder) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	sort.Sort(p.fwdrs)
+	setEnabledMetric(p.name, fwdr)
+
 	if fwdr.Enabled() {
+		if slices.Contains(p.avail, fwdr) {
+			// priority of an available forwarder changed
+			p.init()
+			log.Info("[group] forwarder changed priority", "rule_group", p.name, "forwarder", fwdr.Addr(),
+				"priority", fwdr.Priority(), "enabled", len(p.avail), "total", len(p.fwdrs))
+			return
+		}
+
 		if fwdr.Priority() == p.Priority() {
 			p.avail = append(p.avail, fwdr)
 		} else if fwdr.Priority() > p.Priority() {
 			p.init()
 		}
-		log.F("[group] %s: %s(%d) changed status from DISABLED to ENABLED (%d of %d currently enabled)",
-			p.name, fwdr.Addr(), fwdr.Priority(), len(p.avail), len(p.fwdrs))
+		log.Info("[group] forwarder changed status from DISABLED to ENABLED", "rule_group", p.name,
+			"forwarder", fwdr.Addr(), "priority", fwdr.Priority(), "enabled", len(p.avail), "total", len(p.fwdrs))
 	} else {
 		for i, f := range p.avail {
 			if f == fwdr {
@@ -176,8 +283,8 @@ func (p *FwdrGroup) onStatusChanged(fwdr *Forwarder) {
 				break
 			}
 		}
-		log.F("[group] %s: %s(%d) changed status from ENABLED to DISABLED (%d of %d currently enabled)",
-			p.name, fwdr.Addr(), fwdr.Priority(), len(p.avail), len(p.fwdrs))
+		log.Warn("[group] forwarder changed status from ENABLED to DISABLED", "rule_group", p.name,
+			"forwarder", fwdr.Addr(), "priority", fwdr.Priority(), "enabled", len(p.avail), "total", len(p.fwdrs))
 	}
 
 	if len(p.avail) == 0 {
@@ -185,9 +292,13 @@ func (p *FwdrGroup) onStatusChanged(fwdr *Forwarder) {
 	}
 }
 
-// Check runs the forwarder checks.
+// Check runs the forwarder checks and starts refreshing the subscriptions.
 func (p *FwdrGroup) Check() {
-	if len(p.fwdrs) == 1 {
+	for _, sub := range p.subs {
+		go p.runSub(sub)
+	}
+
+	if len(p.fwdrs) == 1 && len(p.subs) == 0 {
 		log.F("[group] %s: only 1 forwarder found, disable health checking", p.name)
 		return
 	}
@@ -198,7 +309,7 @@ func (p *FwdrGroup) Check() {
 
 	u, err := url.Parse(p.config.Check)
 	if err != nil {
-		log.F("[group] %s: parse check config error: %s, disable health checking", p.name, err)
+		log.Warn("[group] parse check config error, disable health checking", "rule_group", p.name, "error", err.Error())
 		return
 	}
 
@@ -219,14 +330,19 @@ func (p *FwdrGroup) Check() {
 	case "file":
 		checker = newFileChecker(u.Host + u.Path)
 	default:
-		log.F("[group] %s: unknown scheme in check config `%s`, disable health checking", p.name, p.config.Check)
+		log.Warn("[group] unknown scheme in check config, disable health checking", "rule_group", p.name, "check", p.config.Check)
 		return
 	}
 
 	log.F("[group] %s: using check config: %s", p.name, p.config.Check)
 
-	for i := range p.fwdrs {
-		go p.check(p.fwdrs[i], checker)
+	p.mu.Lock()
+	p.checker = checker
+	fwdrs := slices.Clone(p.fwdrs)
+	p.mu.Unlock()
+
+	for _, f := range fwdrs {
+		go p.check(f, checker)
 	}
 }
 
@@ -235,7 +351,16 @@ func (p *FwdrGroup) check(fwdr *Forwarder, checker Checker) {
 	intval := time.Duration(p.config.CheckInterval) * time.Second
 
 	for {
-		time.Sleep(intval * time.Duration(wait))
+		select {
+		case <-p.done:
+			return
+		case <-time.After(intval * time.Duration(wait)):
+		}
+
+		// removed from subscriptions
+		if !p.contains(fwdr) {
+			return
+		}
 
 		// check all forwarders at least one time
 		if wait > 0 && (fwdr.Priority() < p.Priority()) {
@@ -246,12 +371,9 @@ func (p *FwdrGroup) check(fwdr *Forwarder, checker Checker) {
 			continue
 		}
 
-		elapsed, err := checker.Check(fwdr)
+		err := p.checkForwarder(fwdr, checker)
 		if err != nil {
 			if errors.Is(err, proxy.ErrNotSupported) {
-				fwdr.SetMaxFailures(0)
-				log.F("[check] %s: %s(%d), %s, stop checking", p.name, fwdr.Addr(), fwdr.Priority(), err)
-				fwdr.Enable()
 				break
 			}
 
@@ -259,20 +381,64 @@ func (p *FwdrGroup) check(fwdr *Forwarder, checker Checker) {
 			if wait > 16 {
 				wait = 16
 			}
-
-			log.F("[check] %s: %s(%d), FAILED. error: %s", p.name, fwdr.Addr(), fwdr.Priority(), err)
-			fwdr.Disable()
 			continue
 		}
 
 		wait = 1
-		p.setLatency(fwdr, elapsed)
-		log.F("[check] %s: %s(%d), SUCCESS. Elapsed: %dms, Latency: %dms.",
-			p.name, fwdr.Addr(), fwdr.Priority(), elapsed.Milliseconds(), time.Duration(fwdr.Latency()).Milliseconds())
-		fwdr.Enable()
 	}
 }
 
+// contains reports whether fwdr is in the group.
+func (p *FwdrGroup) contains(fwdr *Forwarder) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return slices.Contains(p.fwdrs, fwdr)
+}
+
+// Close stops the health checking of the group.
+func (p *FwdrGroup) Close() {
+	close(p.done)
+}
+
+// CheckForwarder checks the forwarder immediately and updates its status.
+func (p *FwdrGroup) CheckForwarder(fwdr *Forwarder) error {
+	p.mu.RLock()
+	checker := p.checker
+	p.mu.RUnlock()
+
+	if checker == nil {
+		return errors.New("health checking is disabled in group " + p.name)
+	}
+	return p.checkForwarder(fwdr, checker)
+}
+
+// checkForwarder checks the forwarder once and enables or disables it according to the result.
+func (p *FwdrGroup) checkForwarder(fwdr *Forwarder, checker Checker) error {
+	elapsed, err := checker.Check(fwdr)
+	if err != nil {
+		if errors.Is(err, proxy.ErrNotSupported) {
+			fwdr.SetMaxFailures(0)
+			log.Debug("[check] not supported, stop checking", "rule_group", p.name,
+				"forwarder", fwdr.Addr(), "priority", fwdr.Priority(), "error", err.Error())
+			fwdr.Enable()
+			return err
+		}
+
+		log.Debug("[check] FAILED", "rule_group", p.name,
+			"forwarder", fwdr.Addr(), "priority", fwdr.Priority(), "error", err.Error())
+		fwdr.Disable()
+		return err
+	}
+
+	p.setLatency(fwdr, elapsed)
+	checkLatency.With(p.name, fwdr.Addr()).Observe(elapsed.Seconds())
+	log.Debug("[check] SUCCESS", "rule_group", p.name, "forwarder", fwdr.Addr(), "priority", fwdr.Priority(),
+		"duration", elapsed, "latency", time.Duration(fwdr.Latency()))
+	fwdr.Enable()
+
+	return nil
+}
+
 func (p *FwdrGroup) setLatency(fwdr *Forwarder, elapsed time.Duration) {
 	newLatency := int64(elapsed)
 	if cnt := p.config.CheckLatencySamples; cnt > 1 {
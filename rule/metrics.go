@@ -0,0 +1,21 @@
+package rule
+
+import "github.com/nadoo/glider/pkg/metrics"
+
+var (
+	dialErrors = metrics.NewCounterVec("glider_forwarder_dial_errors_total",
+		"Total number of dial errors of forwarders.", "forwarder")
+	fwdrEnabled = metrics.NewGaugeVec("glider_forwarder_enabled",
+		"Whether the forwarder is enabled (1) or disabled (0).", "group", "forwarder")
+	checkLatency = metrics.NewHistogramVec("glider_forwarder_check_duration_seconds",
+		"Duration of the successful forwarder checks.", metrics.DefBuckets, "group", "forwarder")
+)
+
+// setEnabledMetric updates the enabled gauge of forwarder in group.
+func setEnabledMetric(group string, f *Forwarder) {
+	var v float64
+	if f.Enabled() {
+		v = 1
+	}
+	fwdrEnabled.With(group, f.Addr()).Set(v)
+}
@@ -1,8 +1,11 @@
 package rule
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"net/netip"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -10,31 +13,118 @@ import (
 	"github.com/nadoo/glider/proxy"
 )
 
+// geoIPGroup is a rule config with geoip rules and the group it belongs to.
+type geoIPGroup struct {
+	conf  *Config
+	group *FwdrGroup
+}
+
+// ruleSetGroup is a rule set and the group it belongs to.
+type ruleSetGroup struct {
+	rs    *RuleSet
+	group *FwdrGroup
+}
+
 // Proxy implements the proxy.Proxy interface with rule support.
 type Proxy struct {
-	main      *FwdrGroup
-	all       []*FwdrGroup
-	domainMap sync.Map
-	ipMap     sync.Map
-	cidrMap   sync.Map
+	main        *FwdrGroup
+	direct      *FwdrGroup
+	all         []*FwdrGroup
+	userMap     map[string]*FwdrGroup
+	listenerMap map[string]*FwdrGroup
+	srcIPMap    map[netip.Addr]*FwdrGroup
+	srcCIDRs    cidrTrie[*FwdrGroup]
+	domains     *DomainMatcher[*FwdrGroup]
+	geoIPs      []geoIPGroup
+	geoDomains  sync.Map // domains resolved to the ips matched by geoip rules
+	ruleSets    []ruleSetGroup
+	ipMap       sync.Map
+	cidrs       cidrTrie[*FwdrGroup]
+	conds       map[*FwdrGroup]*condition
+	condGroups  []*FwdrGroup // groups with only port or network conditions
 }
 
 // NewProxy returns a new rule proxy.
-func NewProxy(mainForwarders []string, mainStrategy *Strategy, rules []*Config) *Proxy {
-	rd := &Proxy{main: NewFwdrGroup("main", mainForwarders, mainStrategy)}
+func NewProxy(mainForwarders, mainSubs []string, mainStrategy *Strategy, rules []*Config) (*Proxy, error) {
+	main, err := NewFwdrGroup("main", mainForwarders, mainSubs, mainStrategy)
+	if err != nil {
+		return nil, err
+	}
+	rd := &Proxy{
+		main:        main,
+		userMap:     make(map[string]*FwdrGroup),
+		listenerMap: make(map[string]*FwdrGroup),
+		srcIPMap:    make(map[netip.Addr]*FwdrGroup),
+		domains:     NewDomainMatcher[*FwdrGroup](),
+		conds:       make(map[*FwdrGroup]*condition),
+	}
 
 	for _, r := range rules {
-		group := NewFwdrGroup(r.RulePath, r.Forward, &r.Strategy)
+		group, err := NewFwdrGroup(r.RulePath, r.Forward, r.ForwardSub, &r.Strategy)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.RulePath, err)
+		}
 		rd.all = append(rd.all, group)
 
+		if !r.cond.empty() {
+			rd.conds[group] = r.cond
+			if len(r.User)+len(r.Listener)+len(r.SrcIP)+len(r.SrcCIDR)+
+				len(r.Domain)+len(r.DomainFull)+len(r.DomainKeyword)+len(r.DomainRegex)+
+				len(r.IP)+len(r.CIDR)+len(r.GeoIP)+len(r.GeoSite)+len(r.RuleSet) == 0 {
+				rd.condGroups = append(rd.condGroups, group)
+			}
+		}
+
+		for _, user := range r.User {
+			rd.userMap[user] = group
+		}
+
+		for _, listener := range r.Listener {
+			rd.listenerMap[listener] = group
+		}
+
+		for _, s := range r.SrcIP {
+			ip, err := netip.ParseAddr(s)
+			if err != nil {
+				log.Warn("[rule] parse srcip error", "rule_group", group.Name(), "error", err.Error())
+				continue
+			}
+			rd.srcIPMap[ip.Unmap()] = group
+		}
+
+		for _, s := range r.SrcCIDR {
+			cidr, err := netip.ParsePrefix(s)
+			if err != nil {
+				log.Warn("[rule] parse srccidr error", "rule_group", group.Name(), "error", err.Error())
+				continue
+			}
+			rd.srcCIDRs.insert(cidr.Masked(), group)
+		}
+
 		for _, domain := range r.Domain {
-			rd.domainMap.Store(strings.ToLower(domain), group)
+			if err := rd.domains.AddDomain(domain, group); err != nil {
+				log.Warn("[rule] parse domain error", "rule_group", group.Name(), "error", err.Error())
+			}
+		}
+
+		for _, domain := range r.DomainFull {
+			rd.domains.AddFull(domain, group)
+		}
+
+		for _, keyword := range r.DomainKeyword {
+			rd.domains.AddKeyword(keyword, group)
+		}
+
+		for _, expr := range r.DomainRegex {
+			if err := rd.domains.AddRegexp(expr, group); err != nil {
+				log.Warn("[rule] parse domain-regex error", "rule_group", group.Name(), "error", err.Error())
+			}
 		}
 
 		for _, s := range r.IP {
 			ip, err := netip.ParseAddr(s)
 			if err != nil {
-				log.F("[rule] parse ip error: %s", err)
+				log.Warn("[rule] parse ip error", "rule_group", group.Name(), "error", err.Error())
 				continue
 			}
 			rd.ipMap.Store(ip, group)
@@ -43,83 +133,201 @@ func NewProxy(mainForwarders []string, mainStrategy *Strategy, rules []*Config)
 		for _, s := range r.CIDR {
 			cidr, err := netip.ParsePrefix(s)
 			if err != nil {
-				log.F("[rule] parse cidr error: %s", err)
+				log.Warn("[rule] parse cidr error", "rule_group", group.Name(), "error", err.Error())
 				continue
 			}
-			rd.cidrMap.Store(cidr, group)
+			rd.cidrs.insert(cidr.Masked(), group)
+		}
+
+		if len(r.GeoIP) > 0 {
+			rd.geoIPs = append(rd.geoIPs, geoIPGroup{r, group})
+		}
+
+		for _, rs := range r.ruleSets {
+			rd.ruleSets = append(rd.ruleSets, ruleSetGroup{rs, group})
 		}
 	}
 
-	direct := NewFwdrGroup("", nil, mainStrategy)
-	rd.domainMap.Store("direct", direct)
+	direct, err := NewFwdrGroup("direct", nil, nil, mainStrategy)
+	if err != nil {
+		return nil, err
+	}
+	rd.direct = direct
+	rd.domains.AddDomain("direct", direct)
 
-	// if there's any forwarder defined in main config, make sure they will be accessed directly.
-	if len(mainForwarders) > 0 {
-		for _, f := range rd.main.fwdrs {
-			addr := strings.Split(f.addr, ",")[0]
-			host, _, _ := net.SplitHostPort(addr)
-			if _, err := netip.ParseAddr(host); err != nil {
-				rd.domainMap.Store(strings.ToLower(host), direct)
-			}
+	// if there's any forwarder defined in main config, make sure they will be accessed directly,
+	// the subscribed forwarders are checked in findDst as they may change.
+	for _, f := range rd.main.static {
+		if host := forwarderHost(f); host != "" {
+			rd.domains.AddDomain(host, direct)
 		}
 	}
+	rd.domains.Compile()
 
-	return rd
+	return rd, nil
 }
 
 // Dial dials to targer addr and return a conn.
-func (p *Proxy) Dial(network, addr string) (net.Conn, proxy.Dialer, error) {
-	return p.findDialer(addr).Dial(network, addr)
+func (p *Proxy) Dial(meta *proxy.Metadata, network, addr string) (net.Conn, proxy.Dialer, error) {
+	group := p.findDialer(meta, network, addr)
+	c, dialer, err := group.Dial(network, addr)
+	if errors.Is(err, proxy.ErrBlocked) {
+		logBlocked(group, meta, "tcp", addr)
+	}
+	return c, dialer, err
 }
 
 // DialUDP connects to the given address via the proxy.
-func (p *Proxy) DialUDP(network, addr string) (pc net.PacketConn, dialer proxy.UDPDialer, err error) {
-	return p.findDialer(addr).DialUDP(network, addr)
+func (p *Proxy) DialUDP(meta *proxy.Metadata, network, addr string) (pc net.PacketConn, dialer proxy.UDPDialer, err error) {
+	group := p.findDialer(meta, "udp", addr)
+	pc, dialer, err = group.DialUDP(network, addr)
+	if errors.Is(err, proxy.ErrBlocked) {
+		logBlocked(group, meta, "udp", addr)
+	}
+	return pc, dialer, err
 }
 
-// findDialer returns a dialer by dstAddr according to rule.
-func (p *Proxy) findDialer(dstAddr string) *FwdrGroup {
-	host, _, err := net.SplitHostPort(dstAddr)
-	if err != nil {
-		return p.main
+// logBlocked logs the connection from meta to addr blocked by group.
+func logBlocked(group *FwdrGroup, meta *proxy.Metadata, network, addr string) {
+	var client string
+	if meta != nil && meta.Src != nil {
+		client = meta.Src.String()
+	}
+	proxy.LogBlocked(group.Name(), network, client, addr)
+}
+
+// findDialer returns a dialer by source context, network and dstAddr according to rule.
+// the port and network conditions of a rule are checked together with its other matchers,
+// unknown network(empty) or port(0) is not used to reject a matched rule.
+func (p *Proxy) findDialer(meta *proxy.Metadata, network, dstAddr string) *FwdrGroup {
+	host, portStr, err := net.SplitHostPort(dstAddr)
+	port, _ := strconv.ParseUint(portStr, 10, 16)
+
+	// check source context
+	if meta != nil {
+		if group := p.findSrc(meta); group != nil && p.conds[group].match(network, uint16(port), false) {
+			return group
+		}
+	}
+
+	if err == nil {
+		if group := p.findDst(host); group != nil && p.conds[group].match(network, uint16(port), false) {
+			return group
+		}
 	}
 
+	// check rules with only port or network conditions
+	for _, group := range p.condGroups {
+		if p.conds[group].match(network, uint16(port), true) {
+			return group
+		}
+	}
+
+	return p.main
+}
+
+// findSrc returns the group matches the source context, nil if not found.
+func (p *Proxy) findSrc(meta *proxy.Metadata) *FwdrGroup {
+	if group, ok := p.userMap[meta.User]; ok && meta.User != "" {
+		return group
+	}
+
+	if group, ok := p.listenerMap[meta.Listener]; ok && meta.Listener != "" {
+		return group
+	}
+
+	return p.findSrcIP(meta.Src)
+}
+
+// findDst returns the group matches the destination host, nil if not found.
+func (p *Proxy) findDst(host string) *FwdrGroup {
 	if ip, err := netip.ParseAddr(host); err == nil {
 		// check ip
 		if proxy, ok := p.ipMap.Load(ip); ok {
 			return proxy.(*FwdrGroup)
 		}
 
-		// check cidr
-		var ret *FwdrGroup
-		p.cidrMap.Range(func(key, value any) bool {
-			if key.(netip.Prefix).Contains(ip) {
-				ret = value.(*FwdrGroup)
-				return false
-			}
-			return true
-		})
+		// check cidr, the longest prefix wins
+		if group, ok := p.cidrs.lookup(ip); ok {
+			return group
+		}
 
-		if ret != nil {
-			return ret
+		// check geoip
+		if group := p.findGeoIP(ip); group != nil {
+			return group
+		}
+
+		// check rule sets
+		for _, g := range p.ruleSets {
+			if g.rs.List().MatchIP(ip) {
+				return g.group
+			}
 		}
 	}
 
 	// check host
-	host = strings.ToLower(host)
-	for i := len(host); i != -1; {
-		i = strings.LastIndexByte(host[:i], '.')
-		if proxy, ok := p.domainMap.Load(host[i+1:]); ok {
-			return proxy.(*FwdrGroup)
+	if p.main.isSubHost(host) {
+		return p.direct
+	}
+
+	if group, ok := p.domains.Match(host); ok {
+		return group
+	}
+
+	for _, g := range p.ruleSets {
+		if g.rs.List().MatchDomain(host) {
+			return g.group
 		}
 	}
 
-	return p.main
+	// check the domains resolved to ips of geoip rules
+	if group, ok := p.geoDomains.Load(strings.ToLower(host)); ok {
+		return group.(*FwdrGroup)
+	}
+
+	return nil
+}
+
+// findGeoIP returns the group of geoip rules matches ip, nil if not found.
+func (p *Proxy) findGeoIP(ip netip.Addr) *FwdrGroup {
+	for _, g := range p.geoIPs {
+		if g.conf.MatchGeoIP(ip) {
+			return g.group
+		}
+	}
+	return nil
+}
+
+// findSrcIP returns the group matches the source ip, nil if not found.
+func (p *Proxy) findSrcIP(src net.Addr) *FwdrGroup {
+	if src == nil || (len(p.srcIPMap) == 0 && p.srcCIDRs.len() == 0) {
+		return nil
+	}
+
+	addrPort, err := netip.ParseAddrPort(src.String())
+	if err != nil {
+		return nil
+	}
+	ip := addrPort.Addr().Unmap()
+
+	if group, ok := p.srcIPMap[ip]; ok {
+		return group
+	}
+
+	group, _ := p.srcCIDRs.lookup(ip)
+	return group
 }
 
 // NextDialer returns next dialer according to rule.
-func (p *Proxy) NextDialer(dstAddr string) proxy.Dialer {
-	return p.findDialer(dstAddr).NextDialer(dstAddr)
+func (p *Proxy) NextDialer(meta *proxy.Metadata, network, dstAddr string) proxy.Dialer {
+	return p.findDialer(meta, network, dstAddr).NextDialer(dstAddr)
+}
+
+// Blocked reports whether the connections to dstAddr are blocked by rules, and returns
+// the rule group which blocks them. Unlike NextDialer, it doesn't schedule a dialer.
+func (p *Proxy) Blocked(meta *proxy.Metadata, network, dstAddr string) (group string, ok bool) {
+	g := p.findDialer(meta, network, dstAddr)
+	return g.Name(), g.blocks()
 }
 
 // Record records result while using the dialer from proxy.
@@ -133,24 +341,80 @@ func (p *Proxy) Record(dialer proxy.Dialer, success bool) {
 	}
 }
 
-// AddDomainIP used to update ipMap rules according to domainMap rule.
+// AddDomainIP used to update ipMap rules according to domain rules,
+// and remember the domain if ip matches geoip rules.
 func (p *Proxy) AddDomainIP(domain string, ip netip.Addr) error {
-	domain = strings.ToLower(domain)
-	for i := len(domain); i != -1; {
-		i = strings.LastIndexByte(domain[:i], '.')
-		if dialer, ok := p.domainMap.Load(domain[i+1:]); ok {
-			p.ipMap.Store(ip, dialer)
-			// log.F("[rule] update map: %s/%s based on rule: domain=%s\n", domain, ip, domain[i+1:])
+	if group, ok := p.domains.Match(domain); ok {
+		p.ipMap.Store(ip, group)
+		return nil
+	}
+
+	// the ips of rule sets are stored in their lists so they can be replaced together.
+	for _, g := range p.ruleSets {
+		if g.rs.List().AddDomainIP(domain, ip) {
+			return nil
 		}
 	}
+
+	if group := p.findGeoIP(ip); group != nil {
+		p.geoDomains.Store(strings.ToLower(domain), group)
+	}
 	return nil
 }
 
-// Check checks availability of forwarders inside proxy.
+// Groups returns all the forwarder groups, the main group comes first.
+func (p *Proxy) Groups() []*FwdrGroup {
+	return append([]*FwdrGroup{p.main}, p.all...)
+}
+
+// Check checks availability of forwarders inside proxy and starts refreshing the rule sets.
 func (p *Proxy) Check() {
 	p.main.Check()
 
 	for _, fwdrGroup := range p.all {
 		fwdrGroup.Check()
 	}
+
+	for _, g := range p.ruleSets {
+		g.rs.start(p.ruleSetDialer(g.rs))
+	}
+}
+
+// ruleSetDialer returns the dial function to fetch the rule set,
+// the group specified by `via` is used, or routed by rules if not set.
+func (p *Proxy) ruleSetDialer(rs *RuleSet) func(network, addr string) (net.Conn, error) {
+	var group *FwdrGroup
+	if rs.via != "" {
+		for _, g := range append(p.Groups(), p.direct) {
+			if g.Name() == rs.via {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			log.Warn("[ruleset] group not found, routed by rules", "url", rs.url, "rule_group", rs.via)
+		}
+	}
+
+	return func(network, addr string) (net.Conn, error) {
+		g := group
+		if g == nil {
+			g = p.findDialer(nil, network, addr)
+		}
+		c, _, err := g.Dial(network, addr)
+		return c, err
+	}
+}
+
+// Close stops the health checking of forwarders and refreshing of rule sets inside proxy.
+func (p *Proxy) Close() {
+	p.main.Close()
+
+	for _, g := range p.ruleSets {
+		g.rs.Close()
+	}
+
+	for _, fwdrGroup := range p.all {
+		fwdrGroup.Close()
+	}
 }
@@ -0,0 +1,44 @@
+package rule
+
+import (
+	"testing"
+
+	_ "github.com/nadoo/glider/proxy/reject"
+	_ "github.com/nadoo/glider/proxy/socks5"
+)
+
+func TestProxyBlocked(t *testing.T) {
+	p, err := NewProxy([]string{"socks5://127.0.0.1:1080", "socks5://127.0.0.1:1081"}, nil,
+		&Strategy{Strategy: "rr"}, []*Config{
+			{RulePath: "block.rule", Forward: []string{"block://"}, Domain: []string{"blocked.com"}},
+			{RulePath: "reject.rule", Forward: []string{"reject://"}, Domain: []string{"rejected.com"}},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for _, tt := range []struct {
+		addr  string
+		group string
+		ok    bool
+	}{
+		{"www.blocked.com:0", "block", true},
+		{"rejected.com:0", "", false},
+		{"example.com:0", "", false},
+	} {
+		group, ok := p.Blocked(nil, "", tt.addr)
+		if ok != tt.ok || (ok && group != tt.group) {
+			t.Errorf("Blocked(%s) = %s, %v, want %s, %v", tt.addr, group, ok, tt.group, tt.ok)
+		}
+	}
+
+	// Blocked must not advance the round robin scheduler.
+	first := p.NextDialer(nil, "", "example.com:0").Addr()
+	for range 3 {
+		p.Blocked(nil, "", "example.com:0")
+	}
+	if next := p.NextDialer(nil, "", "example.com:0").Addr(); next == first {
+		t.Errorf("NextDialer after Blocked = %s, want the other forwarder", next)
+	}
+}
@@ -0,0 +1,389 @@
+package rule
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nadoo/glider/pkg/log"
+)
+
+const defaultRuleSetInterval = 24 * time.Hour
+
+// RuleSet is a list of rules fetched from a remote url and refreshed periodically,
+// the list is cached on disk so it can be used before fetched on startup.
+type RuleSet struct {
+	url       string
+	interval  time.Duration
+	via       string // name of the group used to fetch the list, empty means routed by rules
+	cacheFile string
+
+	mu       sync.Mutex
+	list     atomic.Pointer[RuleList]
+	handlers []func(old, new *RuleList)
+	done     chan struct{}
+	started  bool
+}
+
+// NewRuleSet returns a new rule set, format of s: URL[#interval=SECONDS&via=GROUP].
+// the cached list in cacheDir will be loaded if exists.
+func NewRuleSet(s, cacheDir string) (*RuleSet, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid ruleset: %s, format: http[s]://HOST/PATH[#interval=SECONDS&via=GROUP]", s)
+	}
+
+	rs := &RuleSet{interval: defaultRuleSetInterval, done: make(chan struct{})}
+
+	params, _ := url.ParseQuery(u.Fragment)
+	if v := params.Get("interval"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid ruleset interval: %s", v)
+		}
+		rs.interval = time.Duration(n) * time.Second
+	}
+	rs.via = params.Get("via")
+
+	u.Fragment = ""
+	rs.url = u.String()
+
+	sum := sha256.Sum256([]byte(rs.url))
+	rs.cacheFile = filepath.Join(cacheDir, fmt.Sprintf("%x.list", sum[:8]))
+
+	list := &RuleList{}
+	if b, err := os.ReadFile(rs.cacheFile); err == nil {
+		list = parseRuleList(b)
+		log.F("[ruleset] loaded %d rules of %s from cache %s", list.Len(), rs.url, rs.cacheFile)
+	}
+	rs.list.Store(list)
+
+	return rs, nil
+}
+
+// URL returns the url of the rule set.
+func (rs *RuleSet) URL() string { return rs.url }
+
+// List returns the current rule list.
+func (rs *RuleSet) List() *RuleList { return rs.list.Load() }
+
+// AddHandler adds a handler called when the rule list changed, the handler
+// is called with a nil old list and the current list immediately.
+func (rs *RuleSet) AddHandler(h func(old, new *RuleList)) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.handlers = append(rs.handlers, h)
+	h(nil, rs.list.Load())
+}
+
+// update replaces the rule list and calls the handlers.
+func (rs *RuleSet) update(list *RuleList) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	old := rs.list.Load()
+	list.inherit(old)
+	rs.list.Store(list)
+
+	for _, h := range rs.handlers {
+		h(old, list)
+	}
+}
+
+// start starts refreshing the rule set, dial is used to connect to the server.
+func (rs *RuleSet) start(dial func(network, addr string) (net.Conn, error)) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.started {
+		return
+	}
+	rs.started = true
+
+	client := newHTTPClient(dial)
+
+	go func() {
+		defer client.CloseIdleConnections()
+
+		// use the cache until it expires
+		var wait time.Duration
+		if fi, err := os.Stat(rs.cacheFile); err == nil {
+			wait = max(time.Until(fi.ModTime().Add(rs.interval)), 0)
+		}
+
+		for {
+			select {
+			case <-rs.done:
+				return
+			case <-time.After(wait):
+			}
+
+			wait = rs.interval
+			if err := rs.refresh(client); err != nil {
+				log.Warn("[ruleset] refresh error", "url", rs.url, "error", err.Error())
+				wait = min(rs.interval, fetchRetryInterval)
+			}
+		}
+	}()
+}
+
+// refresh fetches the rule list and updates it if changed.
+func (rs *RuleSet) refresh(client *http.Client) error {
+	b, err := fetch(client, rs.url)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(rs.cacheFile, b); err != nil {
+		log.Warn("[ruleset] write cache error", "url", rs.url, "error", err.Error())
+	}
+
+	list := parseRuleList(b)
+	if list.hash == rs.list.Load().hash {
+		log.F("[ruleset] %s not changed", rs.url)
+		return nil
+	}
+
+	rs.update(list)
+	log.Info("[ruleset] updated", "url", rs.url, "rules", list.Len())
+
+	return nil
+}
+
+// Close stops refreshing the rule set.
+func (rs *RuleSet) Close() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	select {
+	case <-rs.done:
+	default:
+		close(rs.done)
+	}
+}
+
+const (
+	fetchTimeout       = 30 * time.Second
+	fetchMaxSize       = 64 << 20
+	fetchRetryInterval = time.Minute
+)
+
+// newHTTPClient returns a http client connects to servers with dial.
+func newHTTPClient(dial func(network, addr string) (net.Conn, error)) *http.Client {
+	return &http.Client{
+		Timeout: fetchTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dial(network, addr)
+			},
+		},
+	}
+}
+
+// fetch gets the content of url with client.
+func fetch(client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "glider")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, fetchMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > fetchMaxSize {
+		return nil, errors.New("content too large")
+	}
+
+	return b, nil
+}
+
+// writeFile writes the file atomically.
+func writeFile(name string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+// RuleList is the rules of a rule set, one rule per line, same as in rule files:
+//
+//	domain=example.com
+//	domain-full=www.example.com
+//	domain-keyword=example
+//	domain-regex=^cdn[0-9]+\.
+//	ip=1.1.1.1
+//	cidr=1.1.1.0/24
+//
+// lines without a key are treated as ip, cidr or domain by the value.
+type RuleList struct {
+	Domain        []string
+	DomainFull    []string
+	DomainKeyword []string
+	DomainRegex   []string
+	IP            []string
+	CIDR          []string
+
+	hash     [sha256.Size]byte
+	domains  *DomainMatcher[struct{}]
+	ips      map[netip.Addr]struct{}
+	cidrs    cidrTrie[struct{}]
+	resolved sync.Map // ip -> domain, ips of the domains matched
+}
+
+// parseRuleList parses the rule list, invalid lines are ignored.
+func parseRuleList(b []byte) *RuleList {
+	l := &RuleList{
+		hash:    sha256.Sum256(b),
+		domains: NewDomainMatcher[struct{}](),
+		ips:     make(map[netip.Addr]struct{}),
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			value = line
+			if _, err := netip.ParseAddr(value); err == nil {
+				key = "ip"
+			} else if _, err := netip.ParsePrefix(value); err == nil {
+				key = "cidr"
+			} else {
+				key = "domain"
+			}
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "domain":
+			if l.domains.AddDomain(value, struct{}{}) == nil {
+				l.Domain = append(l.Domain, value)
+			}
+		case "domain-full":
+			l.domains.AddFull(value, struct{}{})
+			l.DomainFull = append(l.DomainFull, value)
+		case "domain-keyword":
+			l.domains.AddKeyword(value, struct{}{})
+			l.DomainKeyword = append(l.DomainKeyword, value)
+		case "domain-regex":
+			if l.domains.AddRegexp(value, struct{}{}) == nil {
+				l.DomainRegex = append(l.DomainRegex, value)
+			}
+		case "ip":
+			if ip, err := netip.ParseAddr(value); err == nil {
+				l.ips[ip.Unmap()] = struct{}{}
+				l.IP = append(l.IP, value)
+			}
+		case "cidr":
+			if cidr, err := netip.ParsePrefix(value); err == nil {
+				l.cidrs.insert(cidr.Masked(), struct{}{})
+				l.CIDR = append(l.CIDR, value)
+			}
+		}
+	}
+	l.domains.Compile()
+
+	return l
+}
+
+// inherit keeps the resolved ips of old list whose domains still match.
+func (l *RuleList) inherit(old *RuleList) {
+	if old == nil {
+		return
+	}
+	old.resolved.Range(func(ip, domain any) bool {
+		if l.MatchDomain(domain.(string)) {
+			l.resolved.Store(ip, domain)
+		}
+		return true
+	})
+}
+
+// Len returns the number of rules in the list.
+func (l *RuleList) Len() int {
+	return len(l.Domain) + len(l.DomainFull) + len(l.DomainKeyword) +
+		len(l.DomainRegex) + len(l.IP) + len(l.CIDR)
+}
+
+// MatchDomain reports whether domain matches the domain rules in the list.
+func (l *RuleList) MatchDomain(domain string) bool {
+	if l.domains == nil {
+		return false
+	}
+	_, ok := l.domains.Match(domain)
+	return ok
+}
+
+// MatchIP reports whether ip matches the ip or cidr rules, or it's resolved from a matched domain.
+func (l *RuleList) MatchIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if _, ok := l.ips[ip]; ok {
+		return true
+	}
+	if _, ok := l.cidrs.lookup(ip); ok {
+		return true
+	}
+	_, ok := l.resolved.Load(ip)
+	return ok
+}
+
+// AddDomainIP records ip if domain matches the domain rules, reports whether it's matched.
+func (l *RuleList) AddDomainIP(domain string, ip netip.Addr) bool {
+	if !l.MatchDomain(domain) {
+		return false
+	}
+	l.resolved.Store(ip.Unmap(), strings.ToLower(domain))
+	return true
+}
+
+// LoadRuleSets creates the rule sets of `ruleset` rules, cacheDir is the folder to cache the lists.
+func LoadRuleSets(rules []*Config, cacheDir string) error {
+	for _, r := range rules {
+		for _, s := range r.RuleSet {
+			rs, err := NewRuleSet(s, cacheDir)
+			if err != nil {
+				return fmt.Errorf("%s: %w", r.RulePath, err)
+			}
+			r.ruleSets = append(r.ruleSets, rs)
+		}
+	}
+	return nil
+}
+
+// RuleSets returns the rule sets of the rule config.
+func (c *Config) RuleSets() []*RuleSet { return c.ruleSets }
@@ -0,0 +1,108 @@
+package rule
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRuleSetRefresh(t *testing.T) {
+	var body atomic.Value
+	body.Store("domain=example.com\n1.1.1.1\n10.0.0.0/8\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer srv.Close()
+
+	rs, err := NewRuleSet(srv.URL+"#interval=60", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := rs.List().Len(); n != 0 {
+		t.Fatalf("rules before refresh = %d, want 0", n)
+	}
+
+	var updates int
+	rs.AddHandler(func(old, new *RuleList) { updates++ })
+
+	if err := rs.refresh(srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	if updates != 2 {
+		t.Fatalf("updates = %d, want 2", updates)
+	}
+
+	l := rs.List()
+	if !l.MatchDomain("www.example.com") || !l.MatchIP(netip.MustParseAddr("10.1.1.1")) || !l.MatchIP(netip.MustParseAddr("1.1.1.1")) {
+		t.Errorf("refreshed list does not match its rules: %+v", l)
+	}
+	if !l.AddDomainIP("www.example.com", netip.MustParseAddr("93.184.216.34")) {
+		t.Fatal("AddDomainIP of a matched domain returns false")
+	}
+
+	// not changed
+	if err := rs.refresh(srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	if updates != 2 {
+		t.Errorf("updates = %d after an unchanged refresh, want 2", updates)
+	}
+
+	// the resolved ips of the domains still in the list are kept
+	body.Store("domain=example.com\n")
+	if err := rs.refresh(srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	l = rs.List()
+	if updates != 3 || l.MatchIP(netip.MustParseAddr("1.1.1.1")) || !l.MatchIP(netip.MustParseAddr("93.184.216.34")) {
+		t.Errorf("updates = %d, list after change: %+v", updates, l)
+	}
+
+	body.Store("domain=example.org\n")
+	if err := rs.refresh(srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	if rs.List().MatchIP(netip.MustParseAddr("93.184.216.34")) {
+		t.Error("resolved ip of a removed domain still matches")
+	}
+}
+
+func TestRuleSetCache(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("example.com\n"))
+	}))
+
+	dir := t.TempDir()
+	rs, err := NewRuleSet(srv.URL, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.refresh(srv.Client()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(rs.cacheFile); err != nil {
+		t.Fatalf("cache file not written: %v", err)
+	}
+
+	// start offline with the cached list
+	client := srv.Client()
+	srv.Close()
+
+	rs, err = NewRuleSet(srv.URL, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.List().MatchDomain("example.com") {
+		t.Fatal("cached list is not loaded")
+	}
+
+	if err := rs.refresh(client); err == nil {
+		t.Fatal("refresh from a closed server succeeded")
+	}
+	if !rs.List().MatchDomain("example.com") {
+		t.Error("cached list is dropped after a failed refresh")
+	}
+}
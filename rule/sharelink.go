@@ -0,0 +1,305 @@
+package rule
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// parseShareLinks decodes the content of a subscription to forward urls,
+// the content is a list of share links, one per line, optionally encoded in base64.
+// links can not be converted are returned in skipped.
+func parseShareLinks(b []byte) (urls []string, skipped []error) {
+	s := strings.TrimSpace(string(b))
+	if !strings.Contains(s, "://") {
+		if d, err := decodeBase64(s); err == nil {
+			s = string(d)
+		}
+	}
+
+	seen := make(map[string]struct{})
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		u, err := shareLinkToURL(line)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+
+		if _, ok := seen[u]; !ok {
+			seen[u] = struct{}{}
+			urls = append(urls, u)
+		}
+	}
+
+	return
+}
+
+// shareLinkToURL converts a share link to a forward url.
+func shareLinkToURL(link string) (string, error) {
+	scheme, _, _ := strings.Cut(link, "://")
+	switch strings.ToLower(scheme) {
+	case "ss":
+		return ssLinkToURL(link)
+	case "vmess":
+		return vmessLinkToURL(link)
+	case "trojan":
+		return trojanLinkToURL(link)
+	case "vless":
+		return vlessLinkToURL(link)
+	}
+	return "", fmt.Errorf("unsupported share link scheme: %s", scheme)
+}
+
+// ssLinkToURL converts a SIP002 link or a legacy base64 encoded ss link:
+//
+//	ss://BASE64URL(METHOD:PASSWORD)@HOST:PORT[/][?plugin=PLUGIN][#NAME]
+//	ss://BASE64(METHOD:PASSWORD@HOST:PORT)[#NAME]
+func ssLinkToURL(link string) (string, error) {
+	body, _, _ := strings.Cut(link[len("ss://"):], "#")
+
+	if !strings.Contains(body, "@") {
+		d, err := decodeBase64(body)
+		if err != nil {
+			return "", fmt.Errorf("invalid ss link: %s", link)
+		}
+		body = string(d)
+	}
+
+	i := strings.LastIndexByte(body, '@')
+	if i == -1 {
+		return "", fmt.Errorf("invalid ss link: %s", link)
+	}
+
+	userInfo := body[:i]
+	if d, err := decodeBase64(userInfo); err == nil && strings.Contains(string(d), ":") {
+		userInfo = string(d)
+	} else if userInfo, err = url.PathUnescape(userInfo); err != nil {
+		return "", fmt.Errorf("invalid ss link: %s", link)
+	}
+
+	method, pass, ok := strings.Cut(userInfo, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid ss link: %s", link)
+	}
+
+	u, err := url.Parse("ss://" + body[i+1:])
+	if err != nil || u.Port() == "" {
+		return "", fmt.Errorf("invalid ss link: %s", link)
+	}
+
+	if u.Query().Get("plugin") != "" {
+		return "", fmt.Errorf("ss plugin not supported: %s", link)
+	}
+
+	return (&url.URL{Scheme: "ss", User: url.UserPassword(method, pass), Host: u.Host}).String(), nil
+}
+
+// vmessLinkToURL converts a vmess link in v2rayN format: vmess://BASE64(JSON).
+func vmessLinkToURL(link string) (string, error) {
+	b, err := decodeBase64(link[len("vmess://"):])
+	if err != nil {
+		return "", fmt.Errorf("invalid vmess link: %s", link)
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", fmt.Errorf("invalid vmess link: %s", link)
+	}
+
+	v := func(key string) string {
+		if val, ok := m[key]; ok && val != nil {
+			return strings.TrimSpace(fmt.Sprint(val))
+		}
+		return ""
+	}
+
+	addr, id := v("add"), v("id")
+	if addr == "" || v("port") == "" || id == "" {
+		return "", fmt.Errorf("invalid vmess link: %s", link)
+	}
+
+	t := &transport{
+		tls:        v("tls") == "tls",
+		serverName: v("sni"),
+		path:       v("path"),
+		host:       v("host"),
+	}
+	if t.serverName == "" {
+		t.serverName = t.host
+	}
+
+	if err := t.setNetwork(v("net"), v("type")); err != nil {
+		return "", err
+	}
+
+	u := &url.URL{Scheme: "vmess", User: url.User(id)}
+	if scy := v("scy"); scy != "" && scy != "auto" {
+		u.User = url.UserPassword(scy, id)
+	}
+	if aid := v("aid"); aid != "" && aid != "0" {
+		u.RawQuery = "alterID=" + aid
+	}
+
+	return t.chain(net.JoinHostPort(addr, v("port")), u), nil
+}
+
+// trojanLinkToURL converts a trojan link:
+//
+//	trojan://PASSWORD@HOST:PORT[?security=tls|none][&sni=SNI][&allowInsecure=1][&type=tcp|ws][&path=PATH][&host=HOST][#NAME]
+func trojanLinkToURL(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil || u.User == nil || u.Port() == "" {
+		return "", fmt.Errorf("invalid trojan link: %s", link)
+	}
+
+	q := u.Query()
+	t, err := linkTransport(q, "tls")
+	if err != nil {
+		return "", err
+	}
+
+	// trojan does tls by itself when no other transport is used.
+	if t.tls && !t.ws {
+		params := url.Values{}
+		if t.serverName != "" {
+			params.Set("serverName", t.serverName)
+		}
+		if t.skipVerify {
+			params.Set("skipVerify", "true")
+		}
+		return (&url.URL{Scheme: "trojan", User: u.User, Host: u.Host, RawQuery: params.Encode()}).String(), nil
+	}
+
+	return t.chain(u.Host, &url.URL{Scheme: "trojanc", User: u.User}), nil
+}
+
+// vlessLinkToURL converts a vless link:
+//
+//	vless://UUID@HOST:PORT[?encryption=none][&security=tls|none][&sni=SNI][&type=tcp|ws][&path=PATH][&host=HOST][#NAME]
+func vlessLinkToURL(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil || u.User == nil || u.Port() == "" {
+		return "", fmt.Errorf("invalid vless link: %s", link)
+	}
+
+	q := u.Query()
+	if enc := q.Get("encryption"); enc != "" && enc != "none" {
+		return "", fmt.Errorf("vless encryption %s not supported: %s", enc, link)
+	}
+	if q.Get("flow") != "" {
+		return "", fmt.Errorf("vless flow not supported: %s", link)
+	}
+
+	t, err := linkTransport(q, "none")
+	if err != nil {
+		return "", err
+	}
+
+	return t.chain(u.Host, &url.URL{Scheme: "vless", User: url.User(u.User.Username())}), nil
+}
+
+// transport is the transport settings in a share link.
+type transport struct {
+	tls        bool
+	serverName string
+	skipVerify bool
+	ws         bool
+	path, host string
+}
+
+// linkTransport returns the transport settings in the query of trojan and vless links.
+func linkTransport(q url.Values, security string) (*transport, error) {
+	if s := q.Get("security"); s != "" {
+		security = s
+	}
+
+	t := &transport{path: q.Get("path"), host: q.Get("host")}
+	switch security {
+	case "tls":
+		t.tls = true
+	case "none":
+	default:
+		return nil, fmt.Errorf("security %s not supported", security)
+	}
+
+	t.serverName = q.Get("sni")
+	if t.serverName == "" {
+		t.serverName = q.Get("peer")
+	}
+	t.skipVerify = q.Get("allowInsecure") == "1" || q.Get("allowInsecure") == "true"
+
+	return t, t.setNetwork(q.Get("type"), q.Get("headerType"))
+}
+
+// setNetwork sets the network type of the transport, only tcp and websocket are supported.
+func (t *transport) setNetwork(network, headerType string) error {
+	switch network {
+	case "", "tcp":
+		if headerType != "" && headerType != "none" {
+			return fmt.Errorf("tcp header type %s not supported", headerType)
+		}
+	case "ws":
+		t.ws = true
+	default:
+		return errors.New("network " + network + " not supported")
+	}
+	return nil
+}
+
+// chain returns the forward url of inner proxy over the transport, inner should be a url without host.
+func (t *transport) chain(addr string, inner *url.URL) string {
+	var chain []string
+	if t.tls {
+		params := url.Values{}
+		if t.serverName != "" {
+			params.Set("serverName", t.serverName)
+		}
+		if t.skipVerify {
+			params.Set("skipVerify", "true")
+		}
+		chain = append(chain, (&url.URL{Scheme: "tls", Host: addr, RawQuery: params.Encode()}).String())
+	}
+
+	if t.ws {
+		ws := &url.URL{Scheme: "ws", Host: addr, Path: t.path}
+		if t.host != "" {
+			ws.RawQuery = "host=" + url.QueryEscape(t.host)
+		}
+		s := ws.String()
+		if t.tls {
+			s = "ws://@" + strings.TrimPrefix(s, "ws://"+addr)
+		}
+		chain = append(chain, s)
+	}
+
+	if len(chain) == 0 {
+		inner.Host = addr
+	}
+
+	return strings.Join(append(chain, inner.String()), ",")
+}
+
+// decodeBase64 decodes s in standard or url base64 encoding, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, "=")
+
+	if b, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,223 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nadoo/glider/pkg/log"
+	"github.com/nadoo/glider/proxy"
+)
+
+const defaultSubInterval = 24 * time.Hour
+
+// subscription is a url of forwarder share links, the forwarders are merged into the group.
+type subscription struct {
+	url      string
+	interval time.Duration
+	fwdrs    []*Forwarder // forwarders from the subscription, guarded by the group's mu
+}
+
+// newSubscription parses the `forwardsub` value, format: URL[#interval=SECONDS].
+func newSubscription(s string) (*subscription, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid forwardsub: %s, format: http[s]://HOST/PATH[#interval=SECONDS]", s)
+	}
+
+	sub := &subscription{interval: defaultSubInterval}
+
+	params, _ := url.ParseQuery(u.Fragment)
+	if v := params.Get("interval"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid forwardsub interval: %s", v)
+		}
+		sub.interval = time.Duration(n) * time.Second
+	}
+
+	u.Fragment = ""
+	sub.url = u.String()
+
+	return sub, nil
+}
+
+// refreshSubs fetches all the subscriptions of the group once.
+func (p *FwdrGroup) refreshSubs() {
+	for _, sub := range p.subs {
+		if err := p.refreshSub(sub); err != nil {
+			log.Warn("[subscription] refresh error", "rule_group", p.name, "url", sub.url, "error", err.Error())
+		}
+	}
+}
+
+// runSub refreshes the subscription periodically until the group is closed.
+func (p *FwdrGroup) runSub(sub *subscription) {
+	p.mu.RLock()
+	wait := sub.interval
+	if len(sub.fwdrs) == 0 { // the first fetch failed
+		wait = min(sub.interval, fetchRetryInterval)
+	}
+	p.mu.RUnlock()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(wait):
+		}
+
+		wait = sub.interval
+		if err := p.refreshSub(sub); err != nil {
+			log.Warn("[subscription] refresh error", "rule_group", p.name, "url", sub.url, "error", err.Error())
+			wait = min(sub.interval, fetchRetryInterval)
+		}
+	}
+}
+
+// refreshSub fetches the subscription and merges the added or removed forwarders into the group,
+// the forwarders still in the subscription are kept with their status.
+func (p *FwdrGroup) refreshSub(sub *subscription) error {
+	b, err := fetch(p.subClient, sub.url)
+	if err != nil {
+		return err
+	}
+
+	urls, skipped := parseShareLinks(b)
+	for _, err := range skipped {
+		log.F("[subscription] %s: skipped: %v", sub.url, err)
+	}
+
+	if len(urls) == 0 {
+		return errors.New("no supported forwarders found")
+	}
+
+	p.mu.RLock()
+	old := make(map[string]*Forwarder, len(sub.fwdrs))
+	for _, f := range sub.fwdrs {
+		old[f.URL()] = f
+	}
+	p.mu.RUnlock()
+
+	var fwdrs, added []*Forwarder
+	for _, u := range urls {
+		if f, ok := old[u]; ok {
+			fwdrs = append(fwdrs, f)
+			delete(old, u)
+			continue
+		}
+
+		f, err := ForwarderFromURL(u, p.config.IntFace,
+			time.Duration(p.config.DialTimeout)*time.Second, time.Duration(p.config.RelayTimeout)*time.Second)
+		if err != nil {
+			log.F("[subscription] %s: skipped: %v", sub.url, err)
+			continue
+		}
+		f.SetMaxFailures(uint32(p.config.MaxFailures))
+		f.group = p.name
+		f.AddHandler(p.onStatusChanged)
+		setEnabledMetric(p.name, f)
+
+		fwdrs = append(fwdrs, f)
+		added = append(added, f)
+	}
+
+	if len(added) == 0 && len(old) == 0 {
+		log.F("[subscription] %s: forwarders not changed", sub.url)
+		return nil
+	}
+
+	p.mu.Lock()
+	sub.fwdrs = fwdrs
+	p.rebuild()
+	checker := p.checker
+	p.mu.Unlock()
+
+	if checker != nil {
+		for _, f := range added {
+			go p.check(f, checker)
+		}
+	}
+
+	for _, f := range old {
+		fwdrEnabled.Delete(p.name, f.Addr())
+		checkLatency.Delete(p.name, f.Addr())
+	}
+
+	log.Info("[subscription] updated forwarders", "rule_group", p.name, "url", sub.url,
+		"added", len(added), "removed", len(old), "total", len(fwdrs))
+
+	return nil
+}
+
+// rebuild rebuilds the forwarders with the static and subscribed ones, a reject
+// forwarder is used when there's none, it must be called with mu held.
+func (p *FwdrGroup) rebuild() {
+	fwdrs := append(priSlice{}, p.static...)
+	p.subHosts = make(map[string]struct{})
+	for _, sub := range p.subs {
+		fwdrs = append(fwdrs, sub.fwdrs...)
+		for _, f := range sub.fwdrs {
+			if host := forwarderHost(f); host != "" {
+				p.subHosts[host] = struct{}{}
+			}
+		}
+	}
+
+	if len(fwdrs) == 0 {
+		fwdrs = append(fwdrs, p.reject)
+	}
+
+	sort.Sort(fwdrs)
+	p.fwdrs = fwdrs
+	p.init()
+}
+
+// isSubHost reports whether host is a subscribed forwarder's hostname or its subdomain.
+func (p *FwdrGroup) isSubHost(host string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.subHosts) == 0 {
+		return false
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for {
+		if _, ok := p.subHosts[host]; ok {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return false
+		}
+		host = host[i+1:]
+	}
+}
+
+// forwarderHost returns the hostname of the first dialer of f, empty if it's an ip.
+func forwarderHost(f *Forwarder) string {
+	addr, _, _ := strings.Cut(f.addr, ",")
+	host, _, _ := net.SplitHostPort(addr)
+	if _, err := netip.ParseAddr(host); err == nil {
+		return ""
+	}
+	return strings.ToLower(host)
+}
+
+// newSubClient returns the http client to fetch subscriptions directly.
+func (p *FwdrGroup) newSubClient() (*http.Client, error) {
+	d, err := proxy.NewDirect(p.config.IntFace,
+		time.Duration(p.config.DialTimeout)*time.Second, time.Duration(p.config.RelayTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return newHTTPClient(d.Dial), nil
+}
@@ -34,6 +34,7 @@ type dhcpd struct {
 	lease  time.Duration
 	iface  *net.Interface
 	server *server4.Server
+	done   chan struct{}
 }
 
 // NewService returns a new dhcpd Service.
@@ -76,11 +77,19 @@ func New(failover bool, args ...string) (*dhcpd, error) {
 		return nil, fmt.Errorf("error in pool init: %s", err)
 	}
 
-	// static ips
+	// static ips and options
 	for _, host := range args[4:] {
-		if mac, ip, ok := strings.Cut(host, "="); ok {
-			if mac, err := net.ParseMAC(mac); err == nil {
-				if ip, err := netip.ParseAddr(ip); err == nil {
+		if key, val, ok := strings.Cut(host, "="); ok {
+			if key == "domain" {
+				if hosts := service.Hosts(); hosts != nil {
+					pool.SetHosts(hosts, val)
+				} else {
+					log.Warn("[dhcpd] dns server is not enabled, hostnames will not be published", "domain", val)
+				}
+				continue
+			}
+			if mac, err := net.ParseMAC(key); err == nil {
+				if ip, err := netip.ParseAddr(val); err == nil {
 					pool.LeaseStaticIP(mac, ip)
 				}
 			}
@@ -93,6 +102,7 @@ func New(failover bool, args ...string) (*dhcpd, error) {
 		pool:     pool,
 		lease:    lease,
 		failover: failover,
+		done:     make(chan struct{}),
 	}
 
 	if dhcpd.server, err = server4.NewServer(
@@ -114,13 +124,24 @@ func (d *dhcpd) Run() {
 		go func() {
 			for {
 				d.setFailover(discovery(d.iface))
-				time.Sleep(time.Second * 60)
+				select {
+				case <-d.done:
+					return
+				case <-time.After(time.Second * 60):
+				}
 			}
 		}()
 	}
 	d.server.Serve()
 }
 
+// Stop stops the service.
+func (d *dhcpd) Stop() {
+	close(d.done)
+	d.server.Close()
+	d.pool.Close()
+}
+
 func (d *dhcpd) handleDHCP(serverIP net.IP, mask net.IPMask, pool *Pool) server4.Handler {
 	return func(conn net.PacketConn, peer net.Addr, m *dhcpv4.DHCPv4) {
 
@@ -188,6 +209,10 @@ func (d *dhcpd) handleDHCP(serverIP net.IP, mask net.IPMask, pool *Pool) server4
 			return
 		}
 
+		if replyType == dhcpv4.MessageTypeAck {
+			pool.SetHostname(replyIP, m.HostName())
+		}
+
 		log.F("[dpcpd] %s: %s to %v for %v",
 			d.name, replyType, resp.ClientHWAddr, replyIP)
 
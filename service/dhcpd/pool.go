@@ -8,8 +8,11 @@ import (
 	"math/rand/v2"
 	"net"
 	"net/netip"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/nadoo/glider/service"
 )
 
 // Pool is a dhcp pool.
@@ -17,12 +20,17 @@ type Pool struct {
 	items []*item
 	mutex sync.RWMutex
 	lease time.Duration
+	done  chan struct{}
+
+	hosts  service.HostRegistry // publishes the hostnames of clients
+	domain string
 }
 
 type item struct {
-	ip     netip.Addr
-	mac    net.HardwareAddr
-	expire time.Time
+	ip       netip.Addr
+	mac      net.HardwareAddr
+	expire   time.Time
+	hostname string // the published hostname with domain
 }
 
 // NewPool returns a new dhcp ip pool.
@@ -41,14 +49,23 @@ func NewPool(lease time.Duration, start, end netip.Addr) (*Pool, error) {
 		items = append(items, &item{ip: numToIPv4(n)})
 	}
 
-	p := &Pool{items: items, lease: lease}
+	p := &Pool{items: items, lease: lease, done: make(chan struct{})}
 	go func() {
-		for now := range time.Tick(time.Second) {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			var now time.Time
+			select {
+			case <-p.done:
+				return
+			case now = <-ticker.C:
+			}
+
 			p.mutex.Lock()
 			for i := range len(items) {
 				if !items[i].expire.IsZero() && now.After(items[i].expire) {
-					items[i].mac = nil
-					items[i].expire = time.Time{}
+					p.release(items[i])
 				}
 			}
 			p.mutex.Unlock()
@@ -58,6 +75,17 @@ func NewPool(lease time.Duration, start, end netip.Addr) (*Pool, error) {
 	return p, nil
 }
 
+// Close stops the lease expiration checking of pool, the published hostnames are removed.
+func (p *Pool) Close() {
+	close(p.done)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, item := range p.items {
+		p.setHostname(item, "")
+	}
+}
+
 // LeaseIP leases an ip to mac from dhcp pool.
 func (p *Pool) LeaseIP(mac net.HardwareAddr, ip netip.Addr) (netip.Addr, error) {
 	p.mutex.Lock()
@@ -124,10 +152,80 @@ func (p *Pool) ReleaseIP(mac net.HardwareAddr) {
 	for _, item := range p.items {
 		// not static ip
 		if !item.expire.IsZero() && bytes.Equal(mac, item.mac) {
-			item.mac = nil
-			item.expire = time.Time{}
+			p.release(item)
+		}
+	}
+}
+
+// release releases the leased item and removes its hostname.
+func (p *Pool) release(item *item) {
+	p.setHostname(item, "")
+	item.mac = nil
+	item.expire = time.Time{}
+}
+
+// SetHosts sets the registry which the hostnames of clients are published to
+// as HOSTNAME.DOMAIN, they are removed when the leases are released or expired.
+func (p *Pool) SetHosts(hosts service.HostRegistry, domain string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.hosts, p.domain = hosts, strings.Trim(strings.ToLower(domain), ".")
+}
+
+// SetHostname sets the hostname of the client which leases ip from pool,
+// the current hostname is kept if hostname is empty, e.g. not sent on renewal.
+func (p *Pool) SetHostname(ip netip.Addr, hostname string) {
+	if strings.TrimSpace(hostname) == "" {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, item := range p.items {
+		if item.ip == ip && item.mac != nil {
+			p.setHostname(item, hostname)
+		}
+	}
+}
+
+func (p *Pool) setHostname(item *item, hostname string) {
+	if p.hosts == nil {
+		return
+	}
+
+	// only the first label of hostname is used
+	hostname, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	if !validHostname(hostname) {
+		hostname = ""
+	} else {
+		hostname += "." + p.domain
+	}
+
+	if item.hostname == hostname {
+		return
+	}
+
+	if item.hostname != "" {
+		p.hosts.RemoveHost(item.hostname, item.ip)
+	}
+	if hostname != "" {
+		p.hosts.AddHost(hostname, item.ip)
+	}
+	item.hostname = hostname
+}
+
+// validHostname reports whether s is a valid host name label, RFC 1123.
+func validHostname(s string) bool {
+	if s == "" || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, c := range s {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+			return false
 		}
 	}
+	return true
 }
 
 func ipv4ToNum(addr netip.Addr) uint32 {
@@ -2,13 +2,23 @@ package service
 
 import (
 	"errors"
+	"net/netip"
 	"strings"
 )
 
 var creators = make(map[string]Creator)
 
-// Service is a server that can be run.
-type Service interface{ Run() }
+// hosts is the registry which the services publish host names to.
+var hosts HostRegistry
+
+// Service is a server that can be run and stopped.
+type Service interface {
+	// Run runs the service, it blocks until the service stopped.
+	Run()
+
+	// Stop stops the service.
+	Stop()
+}
 
 // Creator is a function to create services.
 type Creator func(args ...string) (Service, error)
@@ -27,3 +37,22 @@ func New(s string) (Service, error) {
 	}
 	return nil, errors.New("unknown service name: '" + args[0] + "'")
 }
+
+// HostRegistry is the registry of host names, e.g. the dns server.
+type HostRegistry interface {
+	// AddHost adds host name with ip.
+	AddHost(name string, ip netip.Addr)
+
+	// RemoveHost removes host name with ip.
+	RemoveHost(name string, ip netip.Addr)
+}
+
+// SetHosts sets the host registry, it should be called before creating services.
+func SetHosts(r HostRegistry) {
+	hosts = r
+}
+
+// Hosts returns the host registry, nil if not set.
+func Hosts() HostRegistry {
+	return hosts
+}